@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	// AccessLostPolicyPause moves the reminders to the failed reminders, from which
+	// their owner can snooze them once they have access again.
+	AccessLostPolicyPause = "pause"
+	// AccessLostPolicyCancel removes the reminders.
+	AccessLostPolicyCancel = "cancel"
+	// AccessLostPolicyNotify keeps the reminders and tells their owner, who has until
+	// they are due to get access again.
+	AccessLostPolicyNotify = "notify"
+)
+
+var accessLostPolicies = []string{AccessLostPolicyPause, AccessLostPolicyCancel, AccessLostPolicyNotify}
+
+// UserHasLeftChannel applies the access lost policy to the reminders of the user
+// about posts they can no longer read.
+func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	p.checkReminderAccess(channelMember.UserId)
+}
+
+// UserHasLeftTeam applies the access lost policy to the reminders of the user about
+// posts they can no longer read, leaving a team removes the user from its channels.
+func (p *Plugin) UserHasLeftTeam(c *plugin.Context, teamMember *model.TeamMember, actor *model.User) {
+	p.checkReminderAccess(teamMember.UserId)
+}
+
+// checkReminderAccess applies the access lost policy to the pending reminders of the
+// user about posts they can no longer read.
+func (p *Plugin) checkReminderAccess(userID string) {
+	reminders, err := p.listManager.GetUserIssues(userID)
+	if err != nil {
+		p.API.LogError("Unable to get the reminders of the user. err=" + err.Error())
+		return
+	}
+
+	lost := []*Reminder{}
+	for _, reminder := range reminders {
+		if reminder.State == ReminderStateFailed {
+			continue
+		}
+
+		// Deleted posts are handled when the reminder is due.
+		post, appErr := p.API.GetPost(reminder.PostID)
+		if appErr != nil {
+			continue
+		}
+		channel, appErr := p.API.GetChannel(post.ChannelId)
+		if appErr != nil {
+			continue
+		}
+		if !canReadChannel(p.API, userID, channel) {
+			lost = append(lost, reminder)
+		}
+	}
+
+	p.handleLostAccess(userID, lost, "you can no longer read the post", true)
+}
+
+// checkDeactivatedUsers applies the access lost policy to the pending reminders of
+// deactivated users. The plugin API has no hook for deactivations, so the reconciler
+// looks for them.
+func (p *Plugin) checkDeactivatedUsers() {
+	userIDs, err := p.listManager.GetUsersWithIssues()
+	if err != nil {
+		p.API.LogError("Unable to get the users with reminders. err=" + err.Error())
+		return
+	}
+
+	for _, userID := range userIDs {
+		if p.isActiveUser(userID) {
+			continue
+		}
+
+		reminders, err := p.listManager.GetUserIssues(userID)
+		if err != nil {
+			p.API.LogError("Unable to get the reminders of the user. err=" + err.Error())
+			continue
+		}
+		pending := []*Reminder{}
+		for _, reminder := range reminders {
+			if reminder.State != ReminderStateFailed {
+				pending = append(pending, reminder)
+			}
+		}
+
+		p.handleLostAccess(userID, pending, "you were deactivated", false)
+	}
+}
+
+// handleLostAccess pauses or cancels the reminders of the user, by the access lost
+// policy, and tells them if notify is set. The notify policy leaves the reminders as
+// they are: they fail when due, unless the user got access again.
+func (p *Plugin) handleLostAccess(userID string, reminders []*Reminder, reason string, notify bool) {
+	policy := p.getConfiguration().accessLostPolicy()
+
+	handled := len(reminders)
+	if policy != AccessLostPolicyNotify {
+		handled = 0
+		for _, pending := range reminders {
+			// A reminder being delivered is checked by its delivery.
+			claim := newClusterMutex(p.API, claimKey(pending.ID))
+			claimed, cErr := claim.TryLock(deliveryLease)
+			if cErr != nil || !claimed {
+				continue
+			}
+
+			reminder, rErr := p.listManager.GetIssue(pending.ID)
+			if rErr == nil && reminder.State != ReminderStateFailed && p.applyAccessLostPolicy(reminder, policy, reason) {
+				handled++
+			}
+
+			if uErr := claim.Unlock(); uErr != nil {
+				p.API.LogError("Unable to release the reminder claim. uErr=" + uErr.Error())
+			}
+		}
+	}
+
+	if !notify || handled == 0 {
+		return
+	}
+
+	var message string
+	switch policy {
+	case AccessLostPolicyCancel:
+		message = fmt.Sprintf("I canceled %d of your reminders because %s.", handled, reason)
+	case AccessLostPolicyNotify:
+		message = fmt.Sprintf("%d of your reminders will fail because %s, unless you get access to their posts again before they are due.", handled, reason)
+	default:
+		message = fmt.Sprintf("I paused %d of your reminders because %s. You can find them in your failed reminders, and snooze them once you have access again.", handled, reason)
+	}
+	_ = p.PostBotDM(userID, message)
+}
+
+// isActiveUser tells whether the user was not deactivated. Users who cannot be
+// fetched are considered active, so that an error does not drop their reminders.
+func (p *Plugin) isActiveUser(userID string) bool {
+	user, appErr := p.API.GetUser(userID)
+	return appErr != nil || user.DeleteAt == 0
+}
+
+// applyAccessLostPolicy cancels the reminder, or pauses it for any other policy. It
+// returns whether the reminder was changed.
+func (p *Plugin) applyAccessLostPolicy(reminder *Reminder, policy, reason string) bool {
+	if policy == AccessLostPolicyCancel {
+		if _, err := p.listManager.RemoveIssue(reminder.ID); err != nil {
+			p.API.LogError("Unable to cancel the reminder. err=" + err.Error())
+			return false
+		}
+		p.recordHistory(reminder, HistoryEventCanceled, 0)
+		return true
+	}
+
+	reminder.LastError = reason
+	if err := p.listManager.FailIssue(reminder); err != nil {
+		p.API.LogError("Unable to mark the reminder as failed. err=" + err.Error())
+		return false
+	}
+	p.recordHistory(reminder, HistoryEventFailed, 0)
+	return true
+}
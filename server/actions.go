@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+const (
+	actionSnooze   = "snooze"
+	actionTomorrow = "tomorrow"
+	actionDone     = "done"
+
+	// tomorrowHour is the local hour used by the "Tomorrow" action.
+	tomorrowHour = 9
+)
+
+// reminderActions builds the buttons attached to a reminder delivered to the given
+// recipient. The context carries everything needed to create the reminder again,
+// for the recipient, since a delivered reminder is removed from the store. Only the
+// owner snoozes the reminder for its whole target.
+func (p *Plugin) reminderActions(reminder *Reminder, recipientID string) []*model.PostAction {
+	url := fmt.Sprintf("/plugins/%s/action", manifest.Id)
+	target := ""
+	if reminder.Target != nil && recipientID == reminder.CreateBy {
+		if b, err := json.Marshal(reminder.Target); err == nil {
+			target = string(b)
+		}
+	}
+
+	newAction := func(name, action string, minutes int64) *model.PostAction {
+		return &model.PostAction{
+			Name: name,
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Integration: &model.PostActionIntegration{
+				URL: url,
+				Context: map[string]interface{}{
+					"action":        action,
+					"minutes":       strconv.FormatInt(minutes, 10),
+					"reminder_id":   reminder.ID,
+					"reminder_at":   strconv.FormatInt(reminder.When, 10),
+					"post_id":       reminder.PostID,
+					"message":       reminder.Message,
+					"create_by":     reminder.CreateBy,
+					"recipient":     recipientID,
+					"target":        target,
+					"delivery_mode": reminder.DeliveryMode,
+					"working_time":  strconv.FormatBool(reminder.WorkingTime),
+				},
+			},
+		}
+	}
+
+	actions := []*model.PostAction{}
+	for _, d := range p.getConfiguration().snoozeDurations() {
+		actions = append(actions, newAction("Snooze "+formatDuration(d), actionSnooze, int64(d/time.Minute)))
+	}
+	actions = append(actions,
+		newAction(fmt.Sprintf("Tomorrow %d:00", tomorrowHour), actionTomorrow, 0),
+		newAction("Done", actionDone, 0),
+	)
+
+	return actions
+}
+
+func (p *Plugin) handleAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+	if request == nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Unable to decode the action", fmt.Errorf("invalid action request"))
+		return
+	}
+
+	contextValue := func(key string) string {
+		value, _ := request.Context[key].(string)
+		return value
+	}
+
+	// Buttons of reminders from before recipients existed only carry the owner.
+	recipientID := contextValue("recipient")
+	if recipientID == "" {
+		recipientID = contextValue("create_by")
+	}
+	if recipientID != userID {
+		p.handleErrorWithCode(w, http.StatusForbidden, "Not authorized", fmt.Errorf("reminder belongs to another user"))
+		return
+	}
+
+	loc := p.getUserLocation(userID)
+	now := time.Now().In(loc)
+
+	var remindAt time.Time
+	switch contextValue("action") {
+	case actionSnooze:
+		minutes, err := strconv.ParseInt(contextValue("minutes"), 10, 64)
+		if err != nil || minutes <= 0 {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid snooze duration", fmt.Errorf("invalid minutes %q", contextValue("minutes")))
+			return
+		}
+		remindAt = now.Add(time.Duration(minutes) * time.Minute)
+	case actionTomorrow:
+		remindAt = time.Date(now.Year(), now.Month(), now.Day()+1, tomorrowHour, 0, 0, 0, loc)
+	case actionDone:
+	default:
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Unknown action", fmt.Errorf("unknown action %q", contextValue("action")))
+		return
+	}
+
+	// The delivered reminder, as recorded in the history.
+	delivered := &Reminder{
+		ID:       contextValue("reminder_id"),
+		PostID:   contextValue("post_id"),
+		Message:  contextValue("message"),
+		CreateBy: userID,
+	}
+	delivered.When, _ = strconv.ParseInt(contextValue("reminder_at"), 10, 64)
+
+	status := "Marked as done."
+	if remindAt.IsZero() {
+		p.recordHistory(delivered, HistoryEventDone, 0)
+	} else {
+		when := remindAt.UnixNano() / int64(time.Millisecond)
+		limitReached, err := p.pendingLimitReached(userID)
+		if err != nil {
+			p.API.LogError("Unable to count the pending reminders err=" + err.Error())
+			p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to snooze the reminder", err)
+			return
+		}
+		if limitReached {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Too many pending reminders", fmt.Errorf("at most %d reminders can be pending", p.getConfiguration().MaxPendingReminders))
+			return
+		}
+
+		err = p.listManager.AddIssue(p.newSnoozedReminder(userID, contextValue, when))
+		if err == errPostNotReadable {
+			p.handleErrorWithCode(w, http.StatusNotFound, "Post not found", err)
+			return
+		}
+		if err != nil {
+			p.API.LogError("Unable to snooze the reminder err=" + err.Error())
+			p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to snooze the reminder", err)
+			return
+		}
+		p.recordHistory(delivered, HistoryEventSnoozed, when)
+		status = fmt.Sprintf("Snoozed until %s.", formatUserTime(when, loc))
+	}
+
+	// The buttons are replaced by the status, the preview of the post is kept.
+	response := &model.PostActionIntegrationResponse{}
+	if post, appErr := p.API.GetPost(request.PostId); appErr == nil {
+		attachments := []*model.SlackAttachment{}
+		for _, attachment := range post.Attachments() {
+			attachment.Actions = nil
+			if attachment.Title != "" || attachment.Text != "" || len(attachment.Fields) > 0 {
+				attachments = append(attachments, attachment)
+			}
+		}
+		model.ParseSlackAttachment(post, append(attachments, &model.SlackAttachment{Text: status}))
+		response.Update = post
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// newSnoozedReminder makes the reminder snoozed by a button, due at when, with the
+// target, delivery mode and working time of the delivered reminder.
+func (p *Plugin) newSnoozedReminder(userID string, contextValue func(string) string, when int64) *Reminder {
+	reminder := newReminder(userID, contextValue("message"), contextValue("post_id"), when)
+	reminder.WorkingTime = contextValue("working_time") == "true"
+	if mode := contextValue("delivery_mode"); p.getConfiguration().checkDeliveryMode(mode) == nil {
+		reminder.DeliveryMode = mode
+	}
+
+	if value := contextValue("target"); value != "" && contextValue("create_by") == userID {
+		var target *ReminderTarget
+		if err := json.Unmarshal([]byte(value), &target); err == nil {
+			reminder.Target = target
+		}
+	}
+
+	return reminder
+}
+
+// formatDuration renders durations like "20 min" or "1 h" for button labels.
+func formatDuration(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d h", d/time.Hour)
+	}
+	return fmt.Sprintf("%d min", d/time.Minute)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 200
+
+	// postPreviewLength is the number of characters of the post shown with a reminder.
+	postPreviewLength = 120
+)
+
+// postInfo describes the post of a reminder, as far as the user can read it.
+type postInfo struct {
+	PostPreview string `json:"post_preview"`
+	PostDeleted bool   `json:"post_deleted,omitempty"`
+	ChannelID   string `json:"channel_id,omitempty"`
+	ChannelName string `json:"channel_name,omitempty"`
+}
+
+// reminderView is a reminder as returned by the REST API, along with details about its post.
+type reminderView struct {
+	*Reminder
+	postInfo
+}
+
+type reminderListResponse struct {
+	Reminders []*reminderView `json:"reminders"`
+	Page      int             `json:"page"`
+	PerPage   int             `json:"per_page"`
+	Total     int             `json:"total"`
+}
+
+// handleListReminders returns a page of the pending reminders of the user, earliest first.
+func (p *Plugin) handleListReminders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		p.handleErrorWithCode(w, http.StatusMethodNotAllowed, "Method not allowed", errors.Errorf("%s is not supported", r.Method))
+		return
+	}
+
+	page, perPage, err := parsePagination(r)
+	if err != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid pagination", err)
+		return
+	}
+
+	reminders, total, err := p.listManager.GetUserIssuesPage(userID, page, perPage)
+	if err != nil {
+		p.API.LogError("Unable to list issues err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to list reminders", err)
+		return
+	}
+
+	response := &reminderListResponse{
+		Reminders: []*reminderView{},
+		Page:      page,
+		PerPage:   perPage,
+		Total:     total,
+	}
+	for _, reminder := range reminders {
+		response.Reminders = append(response.Reminders, p.newReminderView(reminder))
+	}
+
+	p.writeJSON(w, response)
+}
+
+// remindTimeRequest describes when to remind. The time is given by exactly one of
+// RememberAt, an offset from now in milliseconds, RemindAt, an absolute instant,
+// or RemindLocal, a wall clock time or a phrase like "tomorrow morning" in the
+// time zone of the user.
+type remindTimeRequest struct {
+	RememberAt  string          `json:"remember_at"`
+	RemindAt    json.RawMessage `json:"remind_at"`
+	RemindLocal string          `json:"remind_local"`
+}
+
+func (t *remindTimeRequest) isSet() bool {
+	return t.RememberAt != "" || t.RemindLocal != "" || (len(t.RemindAt) > 0 && string(t.RemindAt) != "null")
+}
+
+// addAPIRequest describes a new reminder. To lists who to remind, like "@alice",
+// "@developers" or "~release"; the user alone is reminded when it is empty.
+// DeliveryMode defaults to the preference of each user reminded. WorkingTime delays
+// the delivery to each user until their working hours.
+type addAPIRequest struct {
+	remindTimeRequest
+	Message      string      `json:"message"`
+	PostID       string      `json:"post_id"`
+	Recurrence   *Recurrence `json:"recurrence"`
+	To           []string    `json:"to"`
+	DeliveryMode string      `json:"delivery_mode"`
+	WorkingTime  bool        `json:"working_time"`
+}
+
+type patchAPIRequest struct {
+	remindTimeRequest
+	Message      *string `json:"message"`
+	DeliveryMode *string `json:"delivery_mode"`
+	WorkingTime  *bool   `json:"working_time"`
+}
+
+func (p *Plugin) handleAdd(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var addRequest *addAPIRequest
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&addRequest)
+	if err != nil || addRequest == nil {
+		if err == nil {
+			err = errors.New("empty request")
+		}
+		p.API.LogError("Unable to decode JSON err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Unable to decode JSON", err)
+		return
+	}
+
+	when, convErr := p.resolveRemindTime(userID, &addRequest.remindTimeRequest)
+	if convErr != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid reminder time", convErr)
+		return
+	}
+
+	if addRequest.Recurrence != nil {
+		if addRequest.Recurrence.TimeZone == "" {
+			addRequest.Recurrence.TimeZone = p.getUserTimeZone(userID)
+		}
+		if err = addRequest.Recurrence.IsValid(); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid recurrence", err)
+			return
+		}
+	}
+
+	config := p.getConfiguration()
+	if err = config.checkDeliveryMode(addRequest.DeliveryMode); err != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid delivery mode", err)
+		return
+	}
+	if err = config.checkMessage(addRequest.Message); err != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid message", err)
+		return
+	}
+
+	limitReached, err := p.pendingLimitReached(userID)
+	if err != nil {
+		p.API.LogError("Unable to count the pending reminders err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to add issue", err)
+		return
+	}
+	if limitReached {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Too many pending reminders", errors.Errorf("at most %d reminders can be pending", config.MaxPendingReminders))
+		return
+	}
+
+	reminder := newReminder(userID, addRequest.Message, addRequest.PostID, when)
+	reminder.Recurrence = addRequest.Recurrence
+	reminder.DeliveryMode = addRequest.DeliveryMode
+	reminder.WorkingTime = addRequest.WorkingTime
+	reminder.Target, err = p.resolveTarget(userID, addRequest.PostID, addRequest.To)
+	if targetErr, ok := err.(*TargetError); ok {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid recipient", targetErr)
+		return
+	}
+	if err == nil {
+		err = p.listManager.AddIssue(reminder)
+	}
+	if err == errPostNotReadable {
+		p.handleErrorWithCode(w, http.StatusNotFound, "Post not found", err)
+		return
+	}
+	if err != nil {
+		p.API.LogError("Unable to add issue err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to add issue", err)
+		return
+	}
+
+	p.writeJSONWithCode(w, http.StatusCreated, withoutPostMessage(reminder))
+}
+
+// resolveRemindTime returns the instant, in milliseconds, described by the request.
+// It must be in the future, and within the horizon of the configuration.
+func (p *Plugin) resolveRemindTime(userID string, request *remindTimeRequest) (int64, error) {
+	var when int64
+	switch {
+	case len(request.RemindAt) > 0 && string(request.RemindAt) != "null":
+		var value string
+		if err := json.Unmarshal(request.RemindAt, &value); err != nil {
+			// Not a string, so it must be a number of milliseconds.
+			value = string(request.RemindAt)
+		}
+		millis, err := parseInstant(value)
+		if err != nil {
+			return 0, err
+		}
+		when = millis
+	case request.RemindLocal != "":
+		t, err := parseLocalTime(request.RemindLocal, time.Now().In(p.getUserLocation(userID)))
+		if err != nil {
+			return 0, err
+		}
+		when = t.UnixNano() / int64(time.Millisecond)
+	default:
+		remindIn, err := strconv.ParseInt(request.RememberAt, 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("%q is not a number of milliseconds", request.RememberAt)
+		}
+		when = model.GetMillis() + remindIn
+	}
+
+	if err := p.getConfiguration().checkRemindTime(when, model.GetMillis()); err != nil {
+		return 0, err
+	}
+	return when, nil
+}
+
+// pendingLimitReached tells whether the user has as many pending reminders as allowed.
+func (p *Plugin) pendingLimitReached(userID string) (bool, error) {
+	limit := p.getConfiguration().MaxPendingReminders
+	if limit == 0 {
+		return false, nil
+	}
+
+	// Failed reminders are not in the list of the user, so they are not counted.
+	pending, err := p.listManager.CountUserIssues(userID)
+	if err != nil {
+		return false, err
+	}
+	return pending >= limit, nil
+}
+
+// handleFailed lists the reminders of the user that could not be delivered. System
+// admins get the failed reminders of every user.
+func (p *Plugin) handleFailed(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	owner := userID
+	if p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) {
+		owner = ""
+	}
+
+	reminders, err := p.listManager.GetFailedIssues(owner)
+	if err != nil {
+		p.API.LogError("Unable to get failed reminders err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to get failed reminders", err)
+		return
+	}
+
+	views := make([]*Reminder, 0, len(reminders))
+	for _, reminder := range reminders {
+		views = append(views, withoutPostMessage(reminder))
+	}
+	p.writeJSON(w, views)
+}
+
+// handleReminder serves the REST API of a single reminder of the user:
+// GET, PATCH and DELETE /reminders/{id}, and POST /reminders/{id}/snooze.
+func (p *Plugin) handleReminder(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/reminders/"), "/")
+	issueID := parts[0]
+	action := ""
+	if len(parts) > 1 {
+		action = strings.Join(parts[1:], "/")
+	}
+
+	if !model.IsValidId(issueID) {
+		p.handleErrorWithCode(w, http.StatusNotFound, "Reminder not found", errors.Errorf("invalid reminder ID %q", issueID))
+		return
+	}
+
+	reminder, err := p.listManager.GetIssue(issueID)
+	if err != nil {
+		p.handleErrorWithCode(w, http.StatusNotFound, "Reminder not found", err)
+		return
+	}
+	if reminder.CreateBy != userID {
+		p.handleErrorWithCode(w, http.StatusForbidden, "Not authorized", errors.New("the reminder belongs to another user"))
+		return
+	}
+
+	// Changes wait for a delivery in progress, and work on the reminder it left.
+	if (action == "" && (r.Method == http.MethodPatch || r.Method == http.MethodDelete)) || (action == "snooze" && r.Method == http.MethodPost) {
+		claim, err := p.claimReminder(issueID)
+		if err == errReminderBusy {
+			p.handleErrorWithCode(w, http.StatusConflict, "Reminder is being delivered", err)
+			return
+		}
+		if err != nil {
+			p.API.LogError("Unable to claim the reminder err=" + err.Error())
+			p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to claim the reminder", err)
+			return
+		}
+		defer p.releaseReminder(claim)
+
+		if reminder, err = p.listManager.GetIssue(issueID); err != nil {
+			p.handleErrorWithCode(w, http.StatusNotFound, "Reminder not found", err)
+			return
+		}
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		p.writeJSON(w, p.newReminderView(reminder))
+	case action == "" && r.Method == http.MethodPatch:
+		p.handlePatchReminder(w, r, reminder)
+	case action == "" && r.Method == http.MethodDelete:
+		p.handleDeleteReminder(w, reminder)
+	case action == "snooze" && r.Method == http.MethodPost:
+		p.handleSnoozeReminder(w, r, reminder)
+	case action == "" || action == "snooze":
+		p.handleErrorWithCode(w, http.StatusMethodNotAllowed, "Method not allowed", errors.Errorf("%s is not supported", r.Method))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (p *Plugin) handlePatchReminder(w http.ResponseWriter, r *http.Request, reminder *Reminder) {
+	var patchRequest *patchAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&patchRequest); err != nil || patchRequest == nil {
+		if err == nil {
+			err = errors.New("empty request")
+		}
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Unable to decode JSON", err)
+		return
+	}
+
+	when := reminder.When
+	if patchRequest.isSet() {
+		var err error
+		if when, err = p.resolveRemindTime(reminder.CreateBy, &patchRequest.remindTimeRequest); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid reminder time", err)
+			return
+		}
+	}
+	if patchRequest.DeliveryMode != nil {
+		if err := p.getConfiguration().checkDeliveryMode(*patchRequest.DeliveryMode); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid delivery mode", err)
+			return
+		}
+		reminder.DeliveryMode = *patchRequest.DeliveryMode
+	}
+	if patchRequest.WorkingTime != nil {
+		reminder.WorkingTime = *patchRequest.WorkingTime
+	}
+	if patchRequest.Message != nil {
+		if err := p.getConfiguration().checkMessage(*patchRequest.Message); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid message", err)
+			return
+		}
+		reminder.Message = *patchRequest.Message
+	}
+
+	var err error
+	if patchRequest.isSet() {
+		// The new time is the occurrence a recurrence continues from.
+		reminder.ScheduledAt = 0
+		err = p.listManager.RescheduleIssue(reminder, when)
+	} else {
+		// Without a new time, the reminder keeps its state and is not queued again.
+		err = p.listManager.UpdateIssue(reminder)
+	}
+	if err != nil {
+		p.API.LogError("Unable to update issue err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to update the reminder", err)
+		return
+	}
+
+	p.writeJSON(w, p.newReminderView(reminder))
+}
+
+func (p *Plugin) handleDeleteReminder(w http.ResponseWriter, reminder *Reminder) {
+	if _, err := p.listManager.RemoveIssue(reminder.ID); err != nil {
+		p.API.LogError("Unable to remove issue err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to delete the reminder", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Plugin) handleSnoozeReminder(w http.ResponseWriter, r *http.Request, reminder *Reminder) {
+	var snoozeRequest *remindTimeRequest
+	if err := json.NewDecoder(r.Body).Decode(&snoozeRequest); err != nil || snoozeRequest == nil || !snoozeRequest.isSet() {
+		if err == nil {
+			err = errors.New("no reminder time given")
+		}
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid snooze request", err)
+		return
+	}
+
+	when, err := p.resolveRemindTime(reminder.CreateBy, snoozeRequest)
+	if err != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid reminder time", err)
+		return
+	}
+
+	previous := *reminder
+	if err := p.postponeReminder(reminder, when); err != nil {
+		p.API.LogError("Unable to snooze issue err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to snooze the reminder", err)
+		return
+	}
+	p.recordHistory(&previous, HistoryEventSnoozed, reminder.When)
+
+	p.writeJSON(w, p.newReminderView(reminder))
+}
+
+// newReminderView adds the details of the post to a reminder.
+func (p *Plugin) newReminderView(reminder *Reminder) *reminderView {
+	return &reminderView{
+		Reminder: withoutPostMessage(reminder),
+		postInfo: p.getPostInfo(reminder.CreateBy, reminder.PostID),
+	}
+}
+
+// withoutPostMessage returns a copy of the reminder to send over the REST API. The
+// message of the post is only kept in the store, as the user may no longer read it.
+func withoutPostMessage(reminder *Reminder) *Reminder {
+	view := *reminder
+	view.PostMessage = ""
+	return &view
+}
+
+// getPostInfo returns the details of the post shown to the user, if the user can
+// still read it.
+func (p *Plugin) getPostInfo(userID, postID string) postInfo {
+	info := postInfo{}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		info.PostDeleted = appErr.StatusCode == http.StatusNotFound
+		return info
+	}
+
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil || !canReadChannel(p.API, userID, channel) {
+		// The user may have left the channel since the reminder was created.
+		return info
+	}
+	info.PostPreview = truncate(post.Message, postPreviewLength)
+	info.ChannelID = post.ChannelId
+	info.ChannelName = channelDisplayName(channel)
+
+	return info
+}
+
+func parsePagination(r *http.Request) (int, int, error) {
+	query := r.URL.Query()
+
+	page := 0
+	if value := query.Get("page"); value != "" {
+		var err error
+		if page, err = strconv.Atoi(value); err != nil || page < 0 {
+			return 0, 0, fmt.Errorf("invalid page %q", value)
+		}
+	}
+
+	perPage := defaultPerPage
+	if value := query.Get("per_page"); value != "" {
+		var err error
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 {
+			return 0, 0, fmt.Errorf("invalid per_page %q", value)
+		}
+		if perPage > maxPerPage {
+			perPage = maxPerPage
+		}
+	}
+
+	return page, perPage, nil
+}
+
+func (p *Plugin) writeJSON(w http.ResponseWriter, v interface{}) {
+	p.writeJSONWithCode(w, http.StatusOK, v)
+}
+
+func (p *Plugin) writeJSONWithCode(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		p.API.LogError("Unable to marshal JSON err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to marshal JSON", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(b)
+}
+
+// truncate shortens text to at most length characters, marking the cut with an ellipsis.
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "…"
+}
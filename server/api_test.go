@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAPITestPlugin returns a plugin with a post that userID can read.
+func newAPITestPlugin(userID string) (*Plugin, *model.Post) {
+	channel := &model.Channel{Id: model.NewId(), TeamId: model.NewId(), Type: model.CHANNEL_OPEN, DisplayName: "Town Square"}
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id, Message: "Release notes"}
+
+	api := newMemoryKVAPI(map[string][]byte{})
+	api.On("GetPost", post.Id).Return(post, nil)
+	api.On("GetChannel", channel.Id).Return(channel, nil)
+	api.On("HasPermissionToChannel", userID, channel.Id, model.PERMISSION_READ_CHANNEL).Return(true)
+	api.On("GetUser", userID).Return(&model.User{Id: userID}, nil)
+	api.On("LogError", mock.Anything, mock.Anything).Return().Maybe()
+	return newTestPlugin(api), post
+}
+
+func serveAPI(p *Plugin, method, path, userID, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if userID != "" {
+		r.Header.Set("Mattermost-User-ID", userID)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, r)
+	return w
+}
+
+func TestHandleAdd(t *testing.T) {
+	userID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+
+	w := serveAPI(p, http.MethodPost, "/add", "", `{}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serveAPI(p, http.MethodPost, "/add", userID, `null`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAPI(p, http.MethodPost, "/add", userID, `{"post_id": "`+post.Id+`", "remember_at": "-60000"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = serveAPI(p, http.MethodPost, "/add", userID, `{"post_id": "`+post.Id+`", "remember_at": "60000", "message": "Read it"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var reminder Reminder
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reminder))
+	assert.Equal(t, userID, reminder.CreateBy)
+	assert.Equal(t, "Read it", reminder.Message)
+	assert.Empty(t, reminder.PostMessage)
+
+	stored, err := p.listManager.GetIssue(reminder.ID)
+	require.NoError(t, err)
+	assert.Equal(t, reminder.When, stored.When)
+	assert.Equal(t, "Release notes", stored.PostMessage)
+
+	p.setConfiguration(&configuration{MaxPendingReminders: 1})
+	w = serveAPI(p, http.MethodPost, "/add", userID, `{"post_id": "`+post.Id+`", "remember_at": "60000"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Too many pending reminders")
+}
+
+func TestHandleReminder(t *testing.T) {
+	userID := model.NewId()
+	otherUserID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+
+	reminder := newReminder(userID, "Read it", post.Id, model.GetMillis()+60000)
+	require.NoError(t, p.listManager.AddIssue(reminder))
+	path := "/reminders/" + reminder.ID
+
+	t.Run("get", func(t *testing.T) {
+		w := serveAPI(p, http.MethodGet, path, userID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		var view map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &view))
+		assert.Equal(t, reminder.ID, view["id"])
+		assert.Equal(t, "Release notes", view["post_preview"])
+		assert.Equal(t, "Town Square", view["channel_name"])
+		assert.NotContains(t, view, "post_message")
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+			w := serveAPI(p, method, path, otherUserID, `{"message": "Mine"}`)
+			assert.Equal(t, http.StatusForbidden, w.Code, method)
+		}
+
+		w := serveAPI(p, http.MethodGet, "/reminders/"+model.NewId(), userID, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("patch", func(t *testing.T) {
+		w := serveAPI(p, http.MethodPatch, path, userID, `null`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = serveAPI(p, http.MethodPatch, path, userID, `{"message": "Read it twice", "remember_at": "120000"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stored, err := p.listManager.GetIssue(reminder.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Read it twice", stored.Message)
+		assert.Greater(t, stored.When, reminder.When)
+	})
+
+	t.Run("patch the message of a failed reminder", func(t *testing.T) {
+		failed := newReminder(userID, "Read it", post.Id, model.GetMillis()-60000)
+		require.NoError(t, p.listManager.AddIssue(failed))
+		require.NoError(t, p.listManager.FailIssue(failed))
+
+		w := serveAPI(p, http.MethodPatch, "/reminders/"+failed.ID, userID, `{"message": "Read it later"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		stored, err := p.listManager.GetIssue(failed.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "Read it later", stored.Message)
+		assert.Equal(t, ReminderStateFailed, stored.State)
+		assert.Equal(t, failed.When, stored.When)
+		next, ok := p.queue.Next()
+		require.True(t, ok)
+		assert.NotEqual(t, failed.When, next)
+	})
+
+	t.Run("snooze", func(t *testing.T) {
+		w := serveAPI(p, http.MethodPost, path+"/snooze", userID, `{}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = serveAPI(p, http.MethodPut, path+"/snooze", userID, `{"remember_at": "60000"}`)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+		w = serveAPI(p, http.MethodPost, path+"/snooze", userID, `{"remember_at": "180000"}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		stored, err := p.listManager.GetIssue(reminder.ID)
+		require.NoError(t, err)
+		assert.Greater(t, stored.When, model.GetMillis()+120000)
+	})
+
+	t.Run("changes wait for the delivery", func(t *testing.T) {
+		claim, err := p.claimReminder(reminder.ID)
+		require.NoError(t, err)
+
+		for _, method := range []string{http.MethodPatch, http.MethodDelete} {
+			w := serveAPI(p, method, path, userID, `{"message": "Too late"}`)
+			assert.Equal(t, http.StatusConflict, w.Code, method)
+		}
+		w := serveAPI(p, http.MethodGet, path, userID, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		// Other users are turned away before they take the claim.
+		w = serveAPI(p, http.MethodDelete, path, otherUserID, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		p.releaseReminder(claim)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := serveAPI(p, http.MethodDelete, path, userID, "")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := p.listManager.GetIssue(reminder.ID)
+		assert.Error(t, err)
+		w = serveAPI(p, http.MethodGet, path, userID, "")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestHandleListReminders(t *testing.T) {
+	userID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+	later := newReminder(userID, "", post.Id, model.GetMillis()+120000)
+	require.NoError(t, p.listManager.AddIssue(later))
+	require.NoError(t, p.listManager.AddIssue(newReminder(userID, "", post.Id, model.GetMillis()+60000)))
+
+	list := func(query string) *reminderListResponse {
+		w := serveAPI(p, http.MethodGet, "/reminders?"+query, userID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var response reminderListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return &response
+	}
+
+	response := list("per_page=1")
+	assert.Equal(t, 2, response.Total)
+	require.Len(t, response.Reminders, 1)
+	assert.Equal(t, "Release notes", response.Reminders[0].PostPreview)
+
+	response = list("page=1&per_page=1")
+	assert.Equal(t, 2, response.Total)
+	require.Len(t, response.Reminders, 1)
+	assert.Equal(t, later.ID, response.Reminders[0].ID)
+
+	response = list("page=9223372036854775807&per_page=200")
+	assert.Equal(t, 2, response.Total)
+	assert.Empty(t, response.Reminders)
+
+	w := serveAPI(p, http.MethodPost, "/reminders", userID, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	var errorResponse map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errorResponse))
+	assert.Equal(t, "Method not allowed", errorResponse["error"])
+}
+
+func TestHandleAction(t *testing.T) {
+	userID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+
+	delivered := newReminder(userID, "Read it", post.Id, model.GetMillis())
+	delivered.Target = &ReminderTarget{UserIDs: []string{model.NewId()}}
+	delivered.DeliveryMode = DeliveryModeThread
+	delivered.WorkingTime = true
+	delivered.Recurrence = &Recurrence{Rule: RecurrenceDaily, TimeZone: "UTC"}
+
+	actions := p.reminderActions(delivered, userID)
+	require.NotEmpty(t, actions)
+	request := &model.PostActionIntegrationRequest{UserId: userID, PostId: post.Id, Context: actions[0].Integration.Context}
+
+	w := serveAPI(p, http.MethodPost, "/action", userID, string(request.ToJson()))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	reminders, err := p.listManager.GetUserIssues(userID)
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+	snoozed := reminders[0]
+	assert.NotEqual(t, delivered.ID, snoozed.ID)
+	assert.Equal(t, delivered.Target, snoozed.Target)
+	assert.Equal(t, DeliveryModeThread, snoozed.DeliveryMode)
+	assert.True(t, snoozed.WorkingTime)
+	assert.Nil(t, snoozed.Recurrence)
+
+	// The recipients snooze the reminder for themselves only.
+	recipientID := delivered.Target.UserIDs[0]
+	p.API.(*plugintest.API).On("GetUser", recipientID).Return(&model.User{Id: recipientID}, nil)
+	p.API.(*plugintest.API).On("HasPermissionToChannel", recipientID, post.ChannelId, model.PERMISSION_READ_CHANNEL).Return(true)
+	request.Context = p.reminderActions(delivered, recipientID)[0].Integration.Context
+
+	w = serveAPI(p, http.MethodPost, "/action", recipientID, string(request.ToJson()))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	reminders, err = p.listManager.GetUserIssues(recipientID)
+	require.NoError(t, err)
+	require.Len(t, reminders, 1)
+	assert.Nil(t, reminders[0].Target)
+	assert.True(t, reminders[0].WorkingTime)
+}
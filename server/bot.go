@@ -2,26 +2,57 @@ package main
 
 import (
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
 )
 
 // PostBotDM posts a DM as the cloud bot user.
-func (p *Plugin) PostBotDM(userID string, message string) {
-	p.createBotPostDM(&model.Post{
+func (p *Plugin) PostBotDM(userID string, message string) error {
+	return p.createBotPostDM(&model.Post{
 		UserId:  p.BotUserID,
 		Message: message,
 	}, userID)
 }
 
-func (p *Plugin) createBotPostDM(post *model.Post, userID string) {
+// PostBotDMWithAttachment posts a DM as the bot user, with the given attachment below the message.
+func (p *Plugin) PostBotDMWithAttachment(userID string, message string, attachment *model.SlackAttachment) error {
+	post := &model.Post{
+		UserId:  p.BotUserID,
+		Message: message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+
+	return p.createBotPostDM(post, userID)
+}
+
+// PostBotChannelMessage posts a message as the bot user in the given channel, with
+// the given attachment below the message.
+func (p *Plugin) PostBotChannelMessage(channelID string, message string, attachment *model.SlackAttachment) error {
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: channelID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{attachment})
+
+	_, appError := p.API.CreatePost(post)
+	if appError != nil {
+		p.API.LogError("Unable to create bot post err=" + appError.Error())
+		return errors.New(appError.Error())
+	}
+
+	return nil
+}
+
+func (p *Plugin) createBotPostDM(post *model.Post, userID string) error {
 	channel, appError := p.API.GetDirectChannel(userID, p.BotUserID)
 
 	if appError != nil {
 		p.API.LogError("Unable to get direct channel for bot err=" + appError.Error())
-		return
+		return errors.New(appError.Error())
 	}
 	if channel == nil {
-		p.API.LogError("Could not get direct channel for bot and user_id=%s", userID)
-		return
+		p.API.LogError("Could not get direct channel for bot", "user_id", userID)
+		return errors.New("could not get direct channel for bot")
 	}
 
 	post.ChannelId = channel.Id
@@ -29,5 +60,8 @@ func (p *Plugin) createBotPostDM(post *model.Post, userID string) {
 
 	if appError != nil {
 		p.API.LogError("Unable to create bot post DM err=" + appError.Error())
+		return errors.New(appError.Error())
 	}
+
+	return nil
 }
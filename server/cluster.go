@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// StoreLockKey is the key prefix used to store cluster locks in the plugin KV store.
+	StoreLockKey = "lock"
+	// StoreClaimKey is the key prefix used to claim the delivery of a reminder.
+	StoreClaimKey = "claim"
+	// deliveryLease is how long a node may hold a reminder before another node may pick it up.
+	deliveryLease = time.Minute
+)
+
+func lockKey(name string) string {
+	return fmt.Sprintf("%s_%s", StoreLockKey, name)
+}
+
+func claimKey(issueID string) string {
+	return fmt.Sprintf("%s_%s", StoreClaimKey, issueID)
+}
+
+// clusterMutex is a lease based mutex shared by every node of a Mattermost cluster.
+// The lease expires on its own, so a lock held by a dead node is eventually released.
+type clusterMutex struct {
+	api   plugin.API
+	key   string
+	token []byte
+}
+
+func newClusterMutex(api plugin.API, key string) *clusterMutex {
+	return &clusterMutex{
+		api: api,
+		key: key,
+	}
+}
+
+// TryLock acquires the mutex for the given lease without blocking. It reports
+// whether the lock was acquired.
+func (m *clusterMutex) TryLock(lease time.Duration) (bool, error) {
+	seconds := int64(lease / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	token := []byte(model.NewId())
+	ok, appErr := m.api.KVSetWithOptions(m.key, token, model.PluginKVSetOptions{
+		Atomic:          true,
+		OldValue:        nil,
+		ExpireInSeconds: seconds,
+	})
+	if appErr != nil {
+		return false, errors.New(appErr.Error())
+	}
+	if !ok {
+		return false, nil
+	}
+
+	m.token = token
+	return true, nil
+}
+
+// Unlock releases the mutex if it is still held by this instance.
+func (m *clusterMutex) Unlock() error {
+	if m.token == nil {
+		return nil
+	}
+
+	_, appErr := m.api.KVCompareAndDelete(m.key, m.token)
+	m.token = nil
+	if appErr != nil {
+		return errors.New(appErr.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-post-reminder/server/timeparse"
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+const (
+	commandTrigger = "remind"
+
+	// maxTimeWords bounds how many words after the post are tried as the reminder time.
+	maxTimeWords = 8
+)
+
+const commandHelp = `* |/remind add [@user|@group|~channel ...] <permalink|post-id> <time> [message]| - Remind me, or others, about a post, e.g. |/remind add <permalink> tomorrow at 9am check the numbers| or |/remind add @alice ~release <permalink> friday at 17:00|
+* |/remind list| - List my pending reminders
+* |/remind delete <id>| - Delete a reminder
+* |/remind snooze <id> <time>| - Move a reminder to another time, e.g. |/remind snooze <id> in 2 hours|
+* |/remind settings [delivery <dm|thread|ephemeral>]| - Show my settings, or choose how my reminders are delivered by default
+* |/remind settings hours <HH:MM-HH:MM> [days]| - Set my working hours, e.g. |/remind settings hours 09:00-17:00 mon-fri|, or turn them off with |/remind settings hours off|
+* |/remind settings dnd <deliver|hold|silent>| - Choose whether reminders due while I am in Do Not Disturb are delivered, held until it ends, or delivered silently by a direct message
+* |/remind settings ooo <defer|deliver>| - Choose whether reminders due while I am out of office are summed up once I am back, or delivered
+* |/remind help| - Show this help`
+
+func getCommand() *model.Command {
+	return &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "Post Reminder",
+		Description:      "Get reminded about posts.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: add, list, delete, snooze, settings, help",
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAutocompleteData(),
+	}
+}
+
+func getAutocompleteData() *model.AutocompleteData {
+	remind := model.NewAutocompleteData(commandTrigger, "[command]", "Available commands: add, list, delete, snooze, settings, help")
+
+	add := model.NewAutocompleteData("add", "[@user|@group|~channel ...] <permalink|post-id> <time> [message]", "Remind me, or others, about a post")
+	add.AddTextArgument("Who to remind, if not only you, followed by the permalink or ID of the post", "[@user|@group|~channel ...] <permalink|post-id>", "")
+	add.AddTextArgument("When to remind you, e.g. \"in 2 hours\" or \"tomorrow at 9am\", followed by an optional message", "<time> [message]", "")
+	remind.AddCommand(add)
+
+	list := model.NewAutocompleteData("list", "", "List my pending reminders")
+	remind.AddCommand(list)
+
+	remove := model.NewAutocompleteData("delete", "<id>", "Delete a reminder")
+	remove.AddDynamicListArgument("Reminder to delete", "/autocomplete/reminders", true)
+	remind.AddCommand(remove)
+
+	snooze := model.NewAutocompleteData("snooze", "<id> <time>", "Move a reminder to another time")
+	snooze.AddDynamicListArgument("Reminder to snooze", "/autocomplete/reminders", true)
+	snooze.AddTextArgument("When to remind you, e.g. \"in 2 hours\"", "<time>", "")
+	remind.AddCommand(snooze)
+
+	settings := model.NewAutocompleteData("settings", "[delivery|hours|dnd|ooo]", "Show or change my settings")
+	delivery := model.NewAutocompleteData("delivery", "<dm|thread|ephemeral>", "Choose how my reminders are delivered by default")
+	delivery.AddStaticListArgument("Delivery mode", true, []model.AutocompleteListItem{
+		{Item: DeliveryModeDM, HelpText: "A direct message from the bot"},
+		{Item: DeliveryModeThread, HelpText: "A reply in the thread of the post, mentioning me"},
+		{Item: DeliveryModeEphemeral, HelpText: "A message only I can see in the channel of the post, when I am online"},
+	})
+	settings.AddCommand(delivery)
+	hours := model.NewAutocompleteData("hours", "<HH:MM-HH:MM> [days]|off", "Set when reminders delivered at working time reach me")
+	hours.AddTextArgument("Working hours and days, e.g. 09:00-17:00 mon-fri, or off", "<HH:MM-HH:MM> [days]|off", "")
+	settings.AddCommand(hours)
+	dnd := model.NewAutocompleteData("dnd", "<deliver|hold|silent>", "Choose what happens to reminders while I am in Do Not Disturb")
+	dnd.AddStaticListArgument("Do Not Disturb mode", true, []model.AutocompleteListItem{
+		{Item: DoNotDisturbDeliver, HelpText: "Deliver them as usual"},
+		{Item: DoNotDisturbHold, HelpText: "Hold them until Do Not Disturb ends"},
+		{Item: DoNotDisturbSilent, HelpText: "Deliver them by a direct message, without notifying me"},
+	})
+	settings.AddCommand(dnd)
+	ooo := model.NewAutocompleteData("ooo", "<defer|deliver>", "Choose what happens to reminders while I am out of office")
+	ooo.AddStaticListArgument("Out of office mode", true, []model.AutocompleteListItem{
+		{Item: OutOfOfficeDefer, HelpText: "Sum them up once I am back"},
+		{Item: OutOfOfficeDeliver, HelpText: "Deliver them as usual"},
+	})
+	settings.AddCommand(ooo)
+	remind.AddCommand(settings)
+
+	help := model.NewAutocompleteData("help", "", "Show the available commands")
+	remind.AddCommand(help)
+
+	return remind
+}
+
+func (p *Plugin) postCommandResponse(args *model.CommandArgs, text string) {
+	post := &model.Post{
+		UserId:    p.BotUserID,
+		ChannelId: args.ChannelId,
+		Message:   text,
+	}
+	_ = p.API.SendEphemeralPost(args.UserId, post)
+}
+
+// ExecuteCommand executes a given command and returns a command response.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	fields := strings.Fields(args.Command)
+	if len(fields) == 0 || fields[0] != "/"+commandTrigger {
+		return &model.CommandResponse{}, nil
+	}
+
+	subcommand := "help"
+	var params []string
+	if len(fields) > 1 {
+		subcommand = fields[1]
+		params = fields[2:]
+	}
+
+	var message string
+	switch subcommand {
+	case "add":
+		message = p.runAddCommand(args, params)
+	case "list":
+		message = p.runListCommand(args)
+	case "delete":
+		message = p.runDeleteCommand(args, params)
+	case "snooze":
+		message = p.runSnoozeCommand(args, params)
+	case "settings":
+		message = p.runSettingsCommand(args, params)
+	case "help":
+		message = "###### Post Reminder - Slash Command Help\n" + strings.ReplaceAll(commandHelp, "|", "`")
+	default:
+		message = fmt.Sprintf("Unknown command %q.\n\n", subcommand) + strings.ReplaceAll(commandHelp, "|", "`")
+	}
+
+	p.postCommandResponse(args, message)
+	return &model.CommandResponse{}, nil
+}
+
+func (p *Plugin) runAddCommand(args *model.CommandArgs, params []string) string {
+	mentions := []string{}
+	for len(params) > 0 && (strings.HasPrefix(params[0], "@") || strings.HasPrefix(params[0], "~")) {
+		mentions = append(mentions, params[0])
+		params = params[1:]
+	}
+
+	if len(params) < 2 {
+		return "Please give a post and a time, e.g. `/remind add <permalink> in 2 hours`."
+	}
+
+	postID := postIDFromPermalink(params[0])
+	if !model.IsValidId(postID) {
+		return fmt.Sprintf("%q is neither a permalink nor a post ID.", params[0])
+	}
+	loc := p.getUserLocation(args.UserId)
+	when, message, err := splitTimeAndMessage(params[1:], time.Now().In(loc))
+	if err != nil {
+		return fmt.Sprintf("Cannot understand the time: %s.", err.Error())
+	}
+
+	millis := when.UnixNano() / int64(time.Millisecond)
+	config := p.getConfiguration()
+	if err = config.checkRemindTime(millis, model.GetMillis()); err != nil {
+		return fmt.Sprintf("Cannot add the reminder, %s.", err.Error())
+	}
+	if err = config.checkMessage(message); err != nil {
+		return fmt.Sprintf("Cannot add the reminder, %s.", err.Error())
+	}
+
+	limitReached, err := p.pendingLimitReached(args.UserId)
+	if err != nil {
+		p.API.LogError("Unable to count the pending reminders err=" + err.Error())
+		return "Unable to add the reminder."
+	}
+	if limitReached {
+		return fmt.Sprintf("Cannot add the reminder, you already have %d pending reminders. Delete some with `/remind delete <id>` first.", config.MaxPendingReminders)
+	}
+
+	target, err := p.resolveTarget(args.UserId, postID, mentions)
+	if err == errPostNotReadable {
+		return "Cannot find that post."
+	}
+	if err != nil {
+		return fmt.Sprintf("Cannot add the reminder, %s.", err.Error())
+	}
+
+	reminder := newReminder(args.UserId, message, postID, millis)
+	reminder.Target = target
+	err = p.listManager.AddIssue(reminder)
+	if err == errPostNotReadable {
+		return "Cannot find that post."
+	}
+	if err != nil {
+		p.API.LogError("Unable to add issue err=" + err.Error())
+		return "Unable to add the reminder."
+	}
+
+	who := "you"
+	if target != nil {
+		who = strings.Join(mentions, ", ")
+	}
+	return fmt.Sprintf("I will remind %s %s. Reminder ID: `%s`", who, formatUserTime(reminder.When, loc), reminder.ID)
+}
+
+func (p *Plugin) runListCommand(args *model.CommandArgs) string {
+	reminders, err := p.listManager.GetUserIssues(args.UserId)
+	if err != nil {
+		p.API.LogError("Unable to list issues err=" + err.Error())
+		return "Unable to list your reminders."
+	}
+	if len(reminders) == 0 {
+		return "You have no pending reminders."
+	}
+
+	teamName := ""
+	if team, appErr := p.API.GetTeam(args.TeamId); appErr == nil {
+		teamName = team.Name
+	}
+	loc := p.getUserLocation(args.UserId)
+
+	var sb strings.Builder
+	sb.WriteString("| ID | When | Post | Message |\n|:--|:--|:--|:--|\n")
+	for _, reminder := range reminders {
+		postLink := permalinkURL(*p.API.GetConfig().ServiceSettings.SiteURL, teamName, reminder.PostID)
+		sb.WriteString(fmt.Sprintf("| `%s` | %s | [post](%s) | %s |\n", reminder.ID, formatUserTime(reminder.When, loc), postLink, strings.ReplaceAll(reminder.Message, "\n", " ")))
+	}
+
+	return sb.String()
+}
+
+func (p *Plugin) runDeleteCommand(args *model.CommandArgs, params []string) string {
+	if len(params) != 1 {
+		return "Please give the ID of the reminder, e.g. `/remind delete <id>`."
+	}
+
+	_, claim, message := p.claimOwnedIssue(args.UserId, params[0])
+	if message != "" {
+		return message
+	}
+	defer p.releaseReminder(claim)
+
+	if _, err := p.listManager.RemoveIssue(params[0]); err != nil {
+		p.API.LogError("Unable to remove issue err=" + err.Error())
+		return "Unable to delete the reminder."
+	}
+
+	return "Reminder deleted."
+}
+
+func (p *Plugin) runSnoozeCommand(args *model.CommandArgs, params []string) string {
+	if len(params) < 2 {
+		return "Please give the ID of the reminder and a time, e.g. `/remind snooze <id> in 2 hours`."
+	}
+
+	reminder, claim, message := p.claimOwnedIssue(args.UserId, params[0])
+	if message != "" {
+		return message
+	}
+	defer p.releaseReminder(claim)
+
+	loc := p.getUserLocation(args.UserId)
+	when, rest, err := splitTimeAndMessage(params[1:], time.Now().In(loc))
+	if err != nil {
+		return fmt.Sprintf("Cannot understand the time: %s.", err.Error())
+	}
+	if rest != "" {
+		return fmt.Sprintf("Cannot understand %q after the time.", rest)
+	}
+	millis := when.UnixNano() / int64(time.Millisecond)
+	if err := p.getConfiguration().checkRemindTime(millis, model.GetMillis()); err != nil {
+		return fmt.Sprintf("Cannot snooze the reminder, %s.", err.Error())
+	}
+
+	previous := *reminder
+	if err := p.postponeReminder(reminder, millis); err != nil {
+		p.API.LogError("Unable to reschedule issue err=" + err.Error())
+		return "Unable to snooze the reminder."
+	}
+	p.recordHistory(&previous, HistoryEventSnoozed, reminder.When)
+
+	return fmt.Sprintf("Snoozed until %s.", formatUserTime(reminder.When, loc))
+}
+
+func (p *Plugin) runSettingsCommand(args *model.CommandArgs, params []string) string {
+	preferences, err := p.listManager.GetPreferences(args.UserId)
+	if err != nil {
+		p.API.LogError("Unable to get the user preferences err=" + err.Error())
+		return "Unable to get your settings."
+	}
+
+	if len(params) == 0 {
+		mode := preferences.DeliveryMode
+		if mode == "" {
+			mode = DeliveryModeDM
+		}
+		hours := "not set"
+		if preferences.WorkingHours != nil {
+			hours = "`" + preferences.WorkingHours.String() + "`"
+		}
+		dnd := preferences.DoNotDisturb
+		if dnd == "" {
+			dnd = DoNotDisturbDeliver
+		}
+		ooo := preferences.OutOfOffice
+		if ooo == "" {
+			ooo = OutOfOfficeDefer
+		}
+		return fmt.Sprintf("Your reminders are delivered by default as: `%s`.\nYour working hours are %s.\nWhile you are in Do Not Disturb, reminders are: `%s`.\nWhile you are out of office, reminders are: `%s`.", mode, hours, dnd, ooo)
+	}
+
+	var saved string
+	switch params[0] {
+	case "delivery":
+		allowed := p.getConfiguration().allowedDeliveryModes()
+		if len(params) != 2 || !containsString(allowed, params[1]) {
+			return fmt.Sprintf("Please give a delivery mode, one of %s, e.g. `/remind settings delivery %s`.", strings.Join(allowed, ", "), allowed[len(allowed)-1])
+		}
+		preferences.DeliveryMode = params[1]
+		saved = fmt.Sprintf("Your reminders are now delivered by default as: `%s`.", preferences.DeliveryMode)
+	case "hours":
+		if len(params) == 2 && params[1] == "off" {
+			preferences.WorkingHours = nil
+			saved = "Your working hours are now not set."
+			break
+		}
+
+		hours, message := parseWorkingHoursParams(params[1:])
+		if message != "" {
+			return message
+		}
+		preferences.WorkingHours = hours
+		saved = fmt.Sprintf("Your working hours are now `%s`.", hours.String())
+	case "dnd":
+		if len(params) != 2 || !containsString(doNotDisturbModes, params[1]) {
+			return fmt.Sprintf("Please give what happens to reminders while you are in Do Not Disturb, one of %s, e.g. `/remind settings dnd hold`.", strings.Join(doNotDisturbModes, ", "))
+		}
+		preferences.DoNotDisturb = params[1]
+		saved = fmt.Sprintf("While you are in Do Not Disturb, reminders are now: `%s`.", preferences.DoNotDisturb)
+	case "ooo":
+		if len(params) != 2 || !containsString(outOfOfficeModes, params[1]) {
+			return fmt.Sprintf("Please give what happens to reminders while you are out of office, one of %s, e.g. `/remind settings ooo defer`.", strings.Join(outOfOfficeModes, ", "))
+		}
+		preferences.OutOfOffice = params[1]
+		saved = fmt.Sprintf("While you are out of office, reminders are now: `%s`.", preferences.OutOfOffice)
+	default:
+		return "Please give a setting, e.g. `/remind settings delivery thread`, `/remind settings hours 09:00-17:00 mon-fri`, `/remind settings dnd hold` or `/remind settings ooo defer`."
+	}
+
+	if err := p.listManager.SavePreferences(args.UserId, preferences); err != nil {
+		p.API.LogError("Unable to save the user preferences err=" + err.Error())
+		return "Unable to save your settings."
+	}
+
+	return saved
+}
+
+// parseWorkingHoursParams parses working hours like "09:00-17:00 mon-fri", the days
+// defaulting to Monday to Friday, or returns a message explaining why it cannot.
+func parseWorkingHoursParams(params []string) (*WorkingHours, string) {
+	usage := "Please give your working hours and days, e.g. `/remind settings hours 09:00-17:00 mon-fri`, or `/remind settings hours off`."
+	if len(params) == 0 || len(params) > 2 {
+		return nil, usage
+	}
+
+	bounds := strings.SplitN(params[0], "-", 2)
+	if len(bounds) != 2 {
+		return nil, usage
+	}
+	days := "mon-fri"
+	if len(params) == 2 {
+		days = params[1]
+	}
+	weekdays, err := parseWeekdays(days)
+	if err != nil {
+		return nil, fmt.Sprintf("Cannot understand the days: %s.", err.Error())
+	}
+
+	hours := &WorkingHours{Start: bounds[0], End: bounds[1], Days: weekdays}
+	if err := hours.IsValid(); err != nil {
+		return nil, fmt.Sprintf("Cannot understand the working hours: %s.", err.Error())
+	}
+	return hours, ""
+}
+
+// claimOwnedIssue takes the delivery claim of a pending reminder of the user before
+// it is changed, and returns the reminder as left by any delivery in progress. It
+// returns the message to show when it cannot.
+func (p *Plugin) claimOwnedIssue(userID, issueID string) (*Reminder, *clusterMutex, string) {
+	if _, message := p.getOwnedIssue(userID, issueID); message != "" {
+		return nil, nil, message
+	}
+
+	claim, err := p.claimReminder(issueID)
+	if err == errReminderBusy {
+		return nil, nil, "The reminder is being delivered, please try again in a minute."
+	}
+	if err != nil {
+		p.API.LogError("Unable to claim the reminder err=" + err.Error())
+		return nil, nil, "Unable to change the reminder."
+	}
+
+	reminder, message := p.getOwnedIssue(userID, issueID)
+	if message != "" {
+		p.releaseReminder(claim)
+		return nil, nil, message
+	}
+	return reminder, claim, ""
+}
+
+// getOwnedIssue returns the pending reminder with the given ID if it belongs to
+// the user, or a message explaining why it cannot be used.
+func (p *Plugin) getOwnedIssue(userID, issueID string) (*Reminder, string) {
+	reminder, err := p.listManager.GetIssue(issueID)
+	if err != nil || reminder.CreateBy != userID || reminder.State == ReminderStateFailed {
+		return nil, fmt.Sprintf("Cannot find a pending reminder with ID %q.", issueID)
+	}
+	return reminder, ""
+}
+
+// handleAutocompleteReminders lists the pending reminders of the user for the
+// dynamic arguments of the slash command.
+func (p *Plugin) handleAutocompleteReminders(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	reminders, err := p.listManager.GetUserIssues(userID)
+	if err != nil {
+		p.API.LogError("Unable to list issues err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to list reminders", err)
+		return
+	}
+
+	loc := p.getUserLocation(userID)
+	items := []model.AutocompleteListItem{}
+	for _, reminder := range reminders {
+		items = append(items, model.AutocompleteListItem{
+			Item:     reminder.ID,
+			Hint:     formatUserTime(reminder.When, loc),
+			HelpText: reminder.Message,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(model.AutocompleteStaticListItemsToJSON(items))
+}
+
+// postIDFromPermalink returns the post ID of a permalink, or the value itself when
+// it is not a URL.
+func postIDFromPermalink(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return value
+	}
+
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	return segments[len(segments)-1]
+}
+
+// splitTimeAndMessage reads the longest run of leading words that is a valid time,
+// and returns the rest as the message. A time never ends with a filler word, which
+// belongs to the message: "tomorrow at 9am in the office".
+func splitTimeAndMessage(words []string, now time.Time) (time.Time, string, error) {
+	var firstErr error
+	for n := len(words); n > 0; n-- {
+		if n > maxTimeWords || timeparse.IsFillerWord(words[n-1]) {
+			continue
+		}
+
+		when, err := parseLocalTime(strings.Join(words[:n], " "), now)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		return when, strings.Join(words[n:], " "), nil
+	}
+
+	return time.Time{}, "", firstErr
+}
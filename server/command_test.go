@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostIDFromPermalink(t *testing.T) {
+	postID := "pbyh5nxx8tbwznnigfnxqmfaxr"
+
+	assert.Equal(t, postID, postIDFromPermalink(postID))
+	assert.Equal(t, postID, postIDFromPermalink("https://chat.example.com/team/pl/"+postID))
+	assert.Equal(t, postID, postIDFromPermalink("https://chat.example.com/team/pl/"+postID+"/"))
+	assert.Equal(t, postID, postIDFromPermalink("https://chat.example.com/sub/path/team/pl/"+postID+"?view=thread"))
+	assert.Equal(t, "not a link", postIDFromPermalink("not a link"))
+}
+
+func TestSplitTimeAndMessage(t *testing.T) {
+	// Wednesday, 2026-10-14 15:30 UTC.
+	now := time.Date(2026, time.October, 14, 15, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		input    string
+		expected time.Time
+		message  string
+	}{
+		{input: "in 2 hours", expected: now.Add(2 * time.Hour)},
+		{input: "in 2 hours check the build", expected: now.Add(2 * time.Hour), message: "check the build"},
+		{input: "tomorrow at 9am in the office", expected: time.Date(2026, time.October, 15, 9, 0, 0, 0, time.UTC), message: "in the office"},
+		{input: "friday at the standup", expected: time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC), message: "at the standup"},
+		{input: "2026-10-20 08:15 on call handover", expected: time.Date(2026, time.October, 20, 8, 15, 0, 0, time.UTC), message: "on call handover"},
+	} {
+		when, message, err := splitTimeAndMessage(strings.Fields(tc.input), now)
+		require.NoError(t, err, tc.input)
+		assert.Equal(t, tc.expected, when, tc.input)
+		assert.Equal(t, tc.message, message, tc.input)
+	}
+
+	_, _, err := splitTimeAndMessage(strings.Fields("whenever you can"), now)
+	assert.Error(t, err)
+}
@@ -1,11 +1,31 @@
 package main
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// defaultHistoryRetentionDays is the number of days the history of reminders is kept
+	// when the setting is not set.
+	defaultHistoryRetentionDays = 30
+	// defaultSchedulerIntervalSeconds is how often the scheduler looks for reminders
+	// added by other nodes when the setting is not set.
+	defaultSchedulerIntervalSeconds = 60
+	// minSchedulerIntervalSeconds and maxSchedulerIntervalSeconds bound the setting.
+	minSchedulerIntervalSeconds = 5
+	maxSchedulerIntervalSeconds = 3600
+	// defaultSnoozeDurations are the snooze buttons when the setting is not set.
+	defaultSnoozeDurations = "20m,1h"
+	// maxSnoozeDurations bounds the number of snooze buttons.
+	maxSnoozeDurations = 5
+)
+
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
 // configuration, as well as values computed from the configuration. Any public fields will be
 // deserialized from the Mattermost server configuration in OnConfigurationChange.
@@ -18,6 +38,35 @@ import (
 // If you add non-reference types to your configuration struct, be sure to rewrite Clone as a deep
 // copy appropriate for your types.
 type configuration struct {
+	// HistoryRetentionDays is the number of days fired, snoozed, done and failed
+	// reminders are kept in the history of their owner.
+	HistoryRetentionDays int
+
+	// AccessLostPolicy tells what happens to the reminders of users who can no longer
+	// read their post, or who were deactivated: pause, cancel or notify.
+	AccessLostPolicy string
+
+	// SchedulerIntervalSeconds is how often the scheduler reloads the pending reminders,
+	// to pick up those added or released by other nodes.
+	SchedulerIntervalSeconds int
+
+	// MaxPendingReminders bounds the number of pending reminders of a user, 0 for no limit.
+	MaxPendingReminders int
+
+	// MaxHorizonDays bounds how many days ahead a reminder can be set, 0 for no limit.
+	MaxHorizonDays int
+
+	// MaxMessageLength bounds the number of characters of a reminder message, 0 for no limit.
+	MaxMessageLength int
+
+	// SnoozeDurations are the snooze buttons of a delivered reminder, as comma
+	// separated durations like "20m,1h".
+	SnoozeDurations string
+
+	// AllowedDeliveryModes are the comma separated delivery modes users can choose,
+	// all of them when empty. Direct messages are always allowed, the other modes
+	// fall back to them.
+	AllowedDeliveryModes string
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -28,9 +77,157 @@ func (c *configuration) Clone() *configuration {
 }
 
 func (c *configuration) IsValid() error {
+	if c.HistoryRetentionDays < 0 {
+		return errors.New("the history retention must not be negative")
+	}
+	if c.AccessLostPolicy != "" && !containsString(accessLostPolicies, c.AccessLostPolicy) {
+		return errors.Errorf("unknown access lost policy %q", c.AccessLostPolicy)
+	}
+	if c.SchedulerIntervalSeconds != 0 && (c.SchedulerIntervalSeconds < minSchedulerIntervalSeconds || c.SchedulerIntervalSeconds > maxSchedulerIntervalSeconds) {
+		return errors.Errorf("the scheduler interval must be between %d and %d seconds", minSchedulerIntervalSeconds, maxSchedulerIntervalSeconds)
+	}
+	if c.MaxPendingReminders < 0 {
+		return errors.New("the maximum number of pending reminders must not be negative")
+	}
+	if c.MaxHorizonDays < 0 {
+		return errors.New("the maximum reminder horizon must not be negative")
+	}
+	if c.MaxMessageLength < 0 {
+		return errors.New("the maximum message length must not be negative")
+	}
+	if _, err := parseSnoozeDurations(c.SnoozeDurations); err != nil {
+		return err
+	}
+	for _, mode := range splitList(c.AllowedDeliveryModes) {
+		if !isDeliveryMode(mode) {
+			return errors.Errorf("unknown delivery mode %q", mode)
+		}
+	}
+
+	return nil
+}
+
+// schedulerInterval returns how often the scheduler reloads the pending reminders.
+func (c *configuration) schedulerInterval() time.Duration {
+	seconds := c.SchedulerIntervalSeconds
+	if seconds == 0 {
+		seconds = defaultSchedulerIntervalSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// snoozeDurations returns the durations of the snooze buttons.
+func (c *configuration) snoozeDurations() []time.Duration {
+	durations, err := parseSnoozeDurations(c.SnoozeDurations)
+	if err != nil {
+		durations, _ = parseSnoozeDurations(defaultSnoozeDurations)
+	}
+	return durations
+}
+
+// isDeliveryModeAllowed tells whether users can choose the delivery mode.
+func (c *configuration) isDeliveryModeAllowed(mode string) bool {
+	allowed := splitList(c.AllowedDeliveryModes)
+	return mode == DeliveryModeDM || len(allowed) == 0 || containsString(allowed, mode)
+}
+
+// allowedDeliveryModes lists the delivery modes users can choose, the default first.
+func (c *configuration) allowedDeliveryModes() []string {
+	allowed := []string{}
+	for _, mode := range deliveryModes {
+		if c.isDeliveryModeAllowed(mode) {
+			allowed = append(allowed, mode)
+		}
+	}
+	return allowed
+}
+
+// checkDeliveryMode returns an error unless the delivery mode is known and allowed.
+// The empty mode stands for the default of the user.
+func (c *configuration) checkDeliveryMode(mode string) error {
+	if mode == "" {
+		return nil
+	}
+	if !isDeliveryMode(mode) {
+		return errors.Errorf("unknown delivery mode %q", mode)
+	}
+	if !c.isDeliveryModeAllowed(mode) {
+		return errors.Errorf("the delivery mode %q is not allowed", mode)
+	}
+	return nil
+}
+
+// checkRemindTime returns an error if the instant, in milliseconds, is not after now,
+// or is further ahead of now than the maximum horizon.
+func (c *configuration) checkRemindTime(when, now int64) error {
+	if when <= now {
+		return errors.New("the reminder time must be in the future")
+	}
+	if c.MaxHorizonDays > 0 && when > now+(time.Duration(c.MaxHorizonDays)*24*time.Hour).Milliseconds() {
+		return errors.Errorf("reminders cannot be set more than %d days ahead", c.MaxHorizonDays)
+	}
+	return nil
+}
+
+// checkMessage returns an error if the reminder message is longer than allowed.
+func (c *configuration) checkMessage(message string) error {
+	if c.MaxMessageLength > 0 && utf8.RuneCountInString(message) > c.MaxMessageLength {
+		return errors.Errorf("the message cannot be longer than %d characters", c.MaxMessageLength)
+	}
 	return nil
 }
 
+// parseSnoozeDurations parses comma separated durations, in whole minutes. The
+// defaults are used for an empty list.
+func parseSnoozeDurations(list string) ([]time.Duration, error) {
+	values := splitList(list)
+	if len(values) == 0 {
+		values = splitList(defaultSnoozeDurations)
+	}
+	if len(values) > maxSnoozeDurations {
+		return nil, errors.Errorf("at most %d snooze durations can be set", maxSnoozeDurations)
+	}
+
+	durations := make([]time.Duration, 0, len(values))
+	for _, value := range values {
+		d, err := time.ParseDuration(value)
+		if err != nil || d < time.Minute || d%time.Minute != 0 {
+			return nil, fmt.Errorf("invalid snooze duration %q, expected whole minutes like 20m or 1h", value)
+		}
+		durations = append(durations, d)
+	}
+	return durations, nil
+}
+
+// splitList splits a comma separated setting, ignoring blanks.
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// historyRetention returns how long the history of reminders is kept.
+func (c *configuration) historyRetention() time.Duration {
+	days := c.HistoryRetentionDays
+	if days == 0 {
+		days = defaultHistoryRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// accessLostPolicy returns what happens to the reminders of users who lost access
+// to their post.
+func (c *configuration) accessLostPolicy() string {
+	if c.AccessLostPolicy == "" {
+		return AccessLostPolicyPause
+	}
+	return c.AccessLostPolicy
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -80,6 +277,9 @@ func (p *Plugin) OnConfigurationChange() error {
 	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
+	if err := configuration.IsValid(); err != nil {
+		return errors.Wrap(err, "invalid plugin configuration")
+	}
 
 	p.setConfiguration(configuration)
 	return nil
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurationIsValid(t *testing.T) {
+	assert.NoError(t, (&configuration{}).IsValid())
+	assert.NoError(t, (&configuration{SnoozeDurations: "20m, 1h,4h", AllowedDeliveryModes: "dm,thread"}).IsValid())
+
+	for name, c := range map[string]*configuration{
+		"negative retention":     {HistoryRetentionDays: -1},
+		"unknown policy":         {AccessLostPolicy: "ignore"},
+		"scheduler too fast":     {SchedulerIntervalSeconds: 1},
+		"negative pending limit": {MaxPendingReminders: -1},
+		"negative horizon":       {MaxHorizonDays: -1},
+		"negative length":        {MaxMessageLength: -1},
+		"invalid duration":       {SnoozeDurations: "soon"},
+		"partial minute":         {SnoozeDurations: "90s"},
+		"too many durations":     {SnoozeDurations: "1m,2m,3m,4m,5m,6m"},
+		"unknown delivery mode":  {AllowedDeliveryModes: "dm,email"},
+	} {
+		assert.Error(t, c.IsValid(), name)
+	}
+}
+
+func TestConfigurationLimits(t *testing.T) {
+	c := &configuration{MaxHorizonDays: 1, MaxMessageLength: 3, AllowedDeliveryModes: "thread", SnoozeDurations: "5m"}
+	now := int64(1791972000000)
+	day := (24 * time.Hour).Milliseconds()
+
+	assert.NoError(t, c.checkRemindTime(now+day, now))
+	assert.Error(t, c.checkRemindTime(now+day+1, now))
+	assert.NoError(t, (&configuration{}).checkRemindTime(now+1000*day, now))
+	assert.Error(t, (&configuration{}).checkRemindTime(now, now))
+
+	assert.NoError(t, c.checkMessage("été"))
+	assert.Error(t, c.checkMessage("four"))
+
+	assert.Equal(t, []string{DeliveryModeDM, DeliveryModeThread}, c.allowedDeliveryModes())
+	assert.Error(t, c.checkDeliveryMode(DeliveryModeEphemeral))
+	assert.NoError(t, c.checkDeliveryMode(""))
+
+	assert.Equal(t, []time.Duration{5 * time.Minute}, c.snoozeDurations())
+	assert.Equal(t, []time.Duration{20 * time.Minute, time.Hour}, (&configuration{}).snoozeDurations())
+	assert.Equal(t, time.Minute, (&configuration{}).schedulerInterval())
+}
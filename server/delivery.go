@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DeliveryModeDM delivers a reminder by a direct message of the bot.
+	DeliveryModeDM = "dm"
+	// DeliveryModeThread delivers a reminder by a reply in the thread of its post,
+	// mentioning the user.
+	DeliveryModeThread = "thread"
+	// DeliveryModeEphemeral delivers a reminder by an ephemeral post in the channel of
+	// its post, when the user is online.
+	DeliveryModeEphemeral = "ephemeral"
+
+	// postExcerptLength is the number of characters of the post shown in a reminder.
+	postExcerptLength = 300
+	// postDiffLength is the number of characters of the edits of the post shown in a reminder.
+	postDiffLength = 1000
+
+	// noteFieldTitle is the title of the field of a reminder that shows its note.
+	noteFieldTitle = "Note"
+)
+
+// deliveryModes lists the delivery modes, the default first.
+var deliveryModes = []string{DeliveryModeDM, DeliveryModeThread, DeliveryModeEphemeral}
+
+func isDeliveryMode(mode string) bool {
+	return containsString(deliveryModes, mode)
+}
+
+// deliveryMode returns how the reminder is delivered to the user: its own mode, or
+// else the default of the user, as long as the mode is allowed.
+func (p *Plugin) deliveryMode(reminder *Reminder, userID string) string {
+	mode := reminder.DeliveryMode
+	if mode == "" {
+		mode = p.getUserPreferences(userID).DeliveryMode
+	}
+	// The mode may have been disallowed since it was chosen.
+	if mode == "" || !p.getConfiguration().isDeliveryModeAllowed(mode) {
+		return DeliveryModeDM
+	}
+	return mode
+}
+
+// remindUser delivers a reminder to the user in the given delivery mode. It falls
+// back to a direct message when the mode does not apply: the bot cannot reply in
+// direct and group messages, and ephemeral posts are lost on users who are not
+// online. The snooze and done buttons are only offered in direct messages, the
+// only posts they can update once clicked.
+func (p *Plugin) remindUser(reminder *Reminder, userID, mode string, post *model.Post, postChannel *model.Channel, text string, attachment *model.SlackAttachment) error {
+	switch mode {
+	case DeliveryModeThread:
+		if postChannel.IsGroupOrDirect() {
+			break
+		}
+
+		// The note is only made public when its owner chose to reply in the thread.
+		if userID != reminder.CreateBy && reminder.DeliveryMode != DeliveryModeThread {
+			attachment = withoutNote(attachment)
+		}
+
+		rootID := post.RootId
+		if rootID == "" {
+			rootID = post.Id
+		}
+		reply := &model.Post{
+			UserId:    p.BotUserID,
+			ChannelId: post.ChannelId,
+			RootId:    rootID,
+			Message:   fmt.Sprintf("@%s %s", p.listManager.GetUserName(userID), text),
+		}
+		model.ParseSlackAttachment(reply, []*model.SlackAttachment{attachment})
+		if _, appErr := p.API.CreatePost(reply); appErr != nil {
+			p.API.LogError("Unable to create the reminder reply err=" + appErr.Error())
+			return errors.New(appErr.Error())
+		}
+		return nil
+	case DeliveryModeEphemeral:
+		status, appErr := p.API.GetUserStatus(userID)
+		if appErr != nil || status.Status != model.STATUS_ONLINE {
+			break
+		}
+
+		ephemeral := &model.Post{
+			UserId:    p.BotUserID,
+			ChannelId: post.ChannelId,
+			Message:   text,
+		}
+		model.ParseSlackAttachment(ephemeral, []*model.SlackAttachment{attachment})
+		p.API.SendEphemeralPost(userID, ephemeral)
+		return nil
+	}
+
+	withActions := *attachment
+	withActions.Actions = p.reminderActions(reminder, userID)
+	return p.PostBotDMWithAttachment(userID, text, &withActions)
+}
+
+// withoutNote returns a copy of the attachment of a reminder without its note.
+func withoutNote(attachment *model.SlackAttachment) *model.SlackAttachment {
+	copied := *attachment
+	copied.Fields = nil
+	for _, field := range attachment.Fields {
+		if field.Title != noteFieldTitle {
+			copied.Fields = append(copied.Fields, field)
+		}
+	}
+	return &copied
+}
+
+// reminderAttachment shows the post of a reminder to the user: its author, channel
+// and an excerpt, with the note and the creation time of the reminder in the time
+// zone of the user. The title links to the post.
+func (p *Plugin) reminderAttachment(reminder *Reminder, userID string, post *model.Post, postChannel *model.Channel, postLink string) *model.SlackAttachment {
+	attachment := &model.SlackAttachment{
+		Fallback:  "Reminder about " + postLink,
+		Title:     "Open the post",
+		TitleLink: postLink,
+		Text:      truncate(post.Message, postExcerptLength),
+	}
+
+	if author, appErr := p.API.GetUser(post.UserId); appErr == nil {
+		attachment.AuthorName = "@" + author.Username
+		attachment.AuthorIcon = fmt.Sprintf("%s/api/v4/users/%s/image?_=%d", *p.API.GetConfig().ServiceSettings.SiteURL, author.Id, author.LastPictureUpdate)
+	}
+
+	if reminder.Message != "" {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{Title: noteFieldTitle, Value: reminder.Message})
+	}
+	if reminder.PostMessage != "" && reminder.PostMessage != post.Message {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Edited since the reminder was set",
+			Value: "```diff\n" + truncate(lineDiff(reminder.PostMessage, post.Message), postDiffLength) + "\n```",
+		})
+	}
+	attachment.Fields = append(attachment.Fields,
+		&model.SlackAttachmentField{Title: "Channel", Value: channelDisplayName(postChannel), Short: true},
+		&model.SlackAttachmentField{Title: "Set", Value: formatUserTime(reminder.CreateAt, p.getUserLocation(userID)), Short: true},
+	)
+
+	return attachment
+}
+
+// channelDisplayName names a channel for the users: its display name, or the kind of
+// direct message.
+func channelDisplayName(channel *model.Channel) string {
+	switch channel.Type {
+	case model.CHANNEL_DIRECT:
+		return "Direct Message"
+	case model.CHANNEL_GROUP:
+		return "Group Message"
+	default:
+		return channel.DisplayName
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemindUser(t *testing.T) {
+	ownerID := model.NewId()
+	recipientID := model.NewId()
+	channel := &model.Channel{Id: model.NewId(), Type: model.CHANNEL_OPEN}
+	post := &model.Post{Id: model.NewId(), ChannelId: channel.Id}
+
+	var posted []*model.Post
+	api := &plugintest.API{}
+	api.On("GetUser", mock.AnythingOfType("string")).Return(&model.User{Username: "alice"}, nil)
+	api.On("GetUserStatus", recipientID).Return(&model.Status{Status: model.STATUS_ONLINE}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(
+		func(created *model.Post) *model.Post {
+			posted = append(posted, created)
+			return created
+		},
+		func(created *model.Post) *model.AppError { return nil },
+	)
+	api.On("SendEphemeralPost", recipientID, mock.AnythingOfType("*model.Post")).Return(
+		func(userID string, created *model.Post) *model.Post {
+			posted = append(posted, created)
+			return created
+		},
+	)
+	p := newTestPlugin(api)
+
+	remind := func(reminder *Reminder, userID, mode string) *model.SlackAttachment {
+		posted = nil
+		attachment := &model.SlackAttachment{Fields: []*model.SlackAttachmentField{
+			{Title: noteFieldTitle, Value: "Private note"},
+			{Title: "Channel", Value: "Town Square"},
+		}}
+		require.NoError(t, p.remindUser(reminder, userID, mode, post, channel, "Reminder", attachment))
+		require.Len(t, posted, 1)
+		attachments := posted[0].Attachments()
+		require.Len(t, attachments, 1)
+		assert.Empty(t, attachments[0].Actions)
+		return attachments[0]
+	}
+	fieldTitles := func(attachment *model.SlackAttachment) []string {
+		titles := []string{}
+		for _, field := range attachment.Fields {
+			titles = append(titles, field.Title)
+		}
+		return titles
+	}
+
+	reminder := &Reminder{ID: model.NewId(), CreateBy: ownerID, PostID: post.Id}
+	assert.Equal(t, []string{noteFieldTitle, "Channel"}, fieldTitles(remind(reminder, ownerID, DeliveryModeThread)))
+	// The recipient replies in threads by default, the owner did not choose to.
+	assert.Equal(t, []string{"Channel"}, fieldTitles(remind(reminder, recipientID, DeliveryModeThread)))
+	assert.Equal(t, post.Id, posted[0].RootId)
+
+	reminder.DeliveryMode = DeliveryModeThread
+	assert.Equal(t, []string{noteFieldTitle, "Channel"}, fieldTitles(remind(reminder, recipientID, DeliveryModeThread)))
+
+	// Ephemeral posts cannot be updated once a button is clicked.
+	remind(reminder, recipientID, DeliveryModeEphemeral)
+	api.AssertCalled(t, "SendEphemeralPost", recipientID, mock.AnythingOfType("*model.Post"))
+}
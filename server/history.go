@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// StoreHistoryKey is the key prefix used to store the history of the reminders of a user.
+	StoreHistoryKey = "history"
+
+	// HistoryEventFired is recorded when a reminder is delivered.
+	HistoryEventFired = "fired"
+	// HistoryEventSnoozed is recorded when a reminder is moved to a later time.
+	HistoryEventSnoozed = "snoozed"
+	// HistoryEventDone is recorded when a delivered reminder is marked as done.
+	HistoryEventDone = "done"
+	// HistoryEventFailed is recorded when a reminder could not be delivered.
+	HistoryEventFailed = "failed"
+	// HistoryEventPostDeleted is recorded when a reminder is removed because its post was deleted.
+	HistoryEventPostDeleted = "post_deleted"
+	// HistoryEventCanceled is recorded when a reminder is removed because its owner lost
+	// access to its post, or was deactivated.
+	HistoryEventCanceled = "canceled"
+
+	// maxHistoryEntries bounds the number of entries kept for a user, oldest dropped first.
+	maxHistoryEntries = 1000
+)
+
+// HistoryEntry records something that happened to a reminder.
+type HistoryEntry struct {
+	ReminderID   string `json:"reminder_id"`
+	PostID       string `json:"post_id"`
+	Message      string `json:"message"`
+	Event        string `json:"event"`
+	At           int64  `json:"at"`
+	RemindAt     int64  `json:"reminder_at"`
+	SnoozedUntil int64  `json:"snoozed_until,omitempty"`
+}
+
+func historyKey(userID string) string {
+	return fmt.Sprintf("%s_%s", StoreHistoryKey, userID)
+}
+
+// AddHistoryEntry appends an entry to the history of the user and drops the entries
+// older than retention. The history expires once no entry was added for retention.
+func (l *listStore) AddHistoryEntry(userID string, entry *HistoryEntry, retention time.Duration) error {
+	for i := 0; i < StoreRetries; i++ {
+		entries, originalJSONHistory, err := l.getHistory(userID)
+		if err != nil {
+			return err
+		}
+
+		entries = append(pruneHistory(entries, model.GetMillis()-retention.Milliseconds()), entry)
+		if len(entries) > maxHistoryEntries {
+			entries = entries[len(entries)-maxHistoryEntries:]
+		}
+
+		newJSONHistory, jsonErr := json.Marshal(entries)
+		if jsonErr != nil {
+			return jsonErr
+		}
+
+		ok, appErr := l.api.KVSetWithOptions(historyKey(userID), newJSONHistory, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        originalJSONHistory,
+			ExpireInSeconds: int64(retention / time.Second),
+		})
+		if appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("unable to store history")
+}
+
+// GetHistory returns the entries of the history of the user newer than retention,
+// oldest first.
+func (l *listStore) GetHistory(userID string, retention time.Duration) ([]*HistoryEntry, error) {
+	entries, _, err := l.getHistory(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pruneHistory(entries, model.GetMillis()-retention.Milliseconds()), nil
+}
+
+func (l *listStore) getHistory(userID string) ([]*HistoryEntry, []byte, error) {
+	originalJSONHistory, appErr := l.api.KVGet(historyKey(userID))
+	if appErr != nil {
+		return nil, nil, errors.New(appErr.Error())
+	}
+	if originalJSONHistory == nil {
+		return []*HistoryEntry{}, nil, nil
+	}
+
+	var entries []*HistoryEntry
+	if err := json.Unmarshal(originalJSONHistory, &entries); err != nil {
+		return nil, nil, err
+	}
+
+	return entries, originalJSONHistory, nil
+}
+
+func pruneHistory(entries []*HistoryEntry, since int64) []*HistoryEntry {
+	kept := []*HistoryEntry{}
+	for _, entry := range entries {
+		if entry.At >= since {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// recordHistory adds an event of the reminder to the history of its owner. Failing
+// to do so does not fail the operation that caused the event.
+func (p *Plugin) recordHistory(reminder *Reminder, event string, snoozedUntil int64) {
+	entry := &HistoryEntry{
+		ReminderID:   reminder.ID,
+		PostID:       reminder.PostID,
+		Message:      reminder.Message,
+		Event:        event,
+		At:           model.GetMillis(),
+		RemindAt:     reminder.When,
+		SnoozedUntil: snoozedUntil,
+	}
+
+	if err := p.listManager.AddHistoryEntry(reminder.CreateBy, entry, p.getConfiguration().historyRetention()); err != nil {
+		p.API.LogError("Unable to record the reminder history. err=" + err.Error())
+	}
+}
+
+// historyView is a history entry as returned by the REST API, along with details
+// about its post.
+type historyView struct {
+	*HistoryEntry
+	postInfo
+}
+
+type historyListResponse struct {
+	Entries []*historyView `json:"entries"`
+	Page    int            `json:"page"`
+	PerPage int            `json:"per_page"`
+	Total   int            `json:"total"`
+}
+
+// handleHistory returns a page of the history of the user, newest first. It can be
+// narrowed to a period with since and until, in milliseconds, to an event, and to
+// the entries whose message contains q.
+func (p *Plugin) handleHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		p.handleErrorWithCode(w, http.StatusMethodNotAllowed, "Method not allowed", errors.Errorf("%s is not supported", r.Method))
+		return
+	}
+
+	page, perPage, err := parsePagination(r)
+	if err != nil {
+		p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid pagination", err)
+		return
+	}
+
+	query := r.URL.Query()
+	var since, until int64
+	for name, value := range map[string]*int64{"since": &since, "until": &until} {
+		if raw := query.Get(name); raw != "" {
+			if *value, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid "+name, err)
+				return
+			}
+		}
+	}
+	event := query.Get("event")
+	search := strings.ToLower(query.Get("q"))
+
+	entries, err := p.listManager.GetHistory(userID, p.getConfiguration().historyRetention())
+	if err != nil {
+		p.API.LogError("Unable to get the reminder history err=" + err.Error())
+		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to get the reminder history", err)
+		return
+	}
+
+	matching := []*HistoryEntry{}
+	for _, entry := range entries {
+		if (since != 0 && entry.At < since) || (until != 0 && entry.At > until) || (event != "" && entry.Event != event) {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(entry.Message), search) {
+			continue
+		}
+		matching = append(matching, entry)
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].At > matching[j].At
+	})
+
+	response := &historyListResponse{
+		Entries: []*historyView{},
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(matching),
+	}
+	for i := page * perPage; i < len(matching) && i < (page+1)*perPage; i++ {
+		response.Entries = append(response.Entries, &historyView{
+			HistoryEntry: matching[i],
+			postInfo:     p.getPostInfo(userID, matching[i].PostID),
+		})
+	}
+
+	p.writeJSON(w, response)
+}
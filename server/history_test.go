@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	userID := model.NewId()
+	retention := 24 * time.Hour
+	now := model.GetMillis()
+
+	store := &listStore{api: newMemoryKVAPI(map[string][]byte{})}
+
+	expired := &HistoryEntry{ReminderID: model.NewId(), Event: HistoryEventFired, At: now - (25 * time.Hour).Milliseconds()}
+	fired := &HistoryEntry{ReminderID: model.NewId(), Event: HistoryEventFired, At: now - time.Hour.Milliseconds()}
+	snoozed := &HistoryEntry{ReminderID: fired.ReminderID, Event: HistoryEventSnoozed, At: now, SnoozedUntil: now + time.Hour.Milliseconds()}
+
+	require.NoError(t, store.AddHistoryEntry(userID, expired, 48*time.Hour))
+	require.NoError(t, store.AddHistoryEntry(userID, fired, retention))
+	require.NoError(t, store.AddHistoryEntry(userID, snoozed, retention))
+
+	entries, err := store.GetHistory(userID, retention)
+	require.NoError(t, err)
+	assert.Equal(t, []*HistoryEntry{fired, snoozed}, entries)
+
+	entries, err = store.GetHistory(model.NewId(), retention)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+)
+
+// newMemoryKVAPI returns an API whose KV store is kept in the given map.
+func newMemoryKVAPI(kv map[string][]byte) *plugintest.API {
+	api := &plugintest.API{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(
+		func(key string) []byte { return kv[key] },
+		func(key string) *model.AppError { return nil },
+	)
+	api.On("KVSet", mock.AnythingOfType("string"), mock.Anything).Return(
+		func(key string, value []byte) *model.AppError {
+			kv[key] = value
+			return nil
+		},
+	)
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			if options.Atomic && !bytes.Equal(kv[key], options.OldValue) {
+				return false
+			}
+			kv[key] = value
+			return true
+		},
+		func(key string, value []byte, options model.PluginKVSetOptions) *model.AppError { return nil },
+	)
+	api.On("KVDelete", mock.AnythingOfType("string")).Return(
+		func(key string) *model.AppError {
+			delete(kv, key)
+			return nil
+		},
+	)
+	api.On("KVCompareAndSet", mock.AnythingOfType("string"), mock.Anything, mock.Anything).Return(
+		func(key string, oldValue, newValue []byte) bool {
+			if !bytes.Equal(kv[key], oldValue) {
+				return false
+			}
+			kv[key] = newValue
+			return true
+		},
+		func(key string, oldValue, newValue []byte) *model.AppError { return nil },
+	)
+	api.On("KVCompareAndDelete", mock.AnythingOfType("string"), mock.Anything).Return(
+		func(key string, oldValue []byte) bool {
+			if !bytes.Equal(kv[key], oldValue) {
+				return false
+			}
+			delete(kv, key)
+			return true
+		},
+		func(key string, oldValue []byte) *model.AppError { return nil },
+	)
+	api.On("KVList", mock.AnythingOfType("int"), mock.AnythingOfType("int")).Return(
+		func(page, perPage int) []string {
+			keys := []string{}
+			for key := range kv {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if page*perPage >= len(keys) {
+				return []string{}
+			}
+			keys = keys[page*perPage:]
+			if len(keys) > perPage {
+				keys = keys[:perPage]
+			}
+			return keys
+		},
+		func(page, perPage int) *model.AppError { return nil },
+	)
+	api.On("LogInfo", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	return api
+}
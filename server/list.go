@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -14,39 +16,106 @@ type ListStore interface {
 	RemoveReminder(issueID string) error
 	GetAndRemoveReminder(issueID string) (*Reminder, error)
 	GetList() ([]*ReminderRef, error)
+	GetDueList() ([]*ReminderRef, error)
+	GetDueListBetween(since, until int64) ([]*ReminderRef, error)
+	GetFailedList() ([]*ReminderRef, error)
+	GetUserList(userID string) ([]*ReminderRef, error)
+	GetUserIDs() ([]string, error)
+	AddAwayEntry(userID string, entry *AwayEntry) error
+	GetAwayEntries(userID string) ([]*AwayEntry, error)
+	RemoveAwayEntries(userID string, count int) error
+	GetAwayUserIDs() ([]string, error)
+	Migrate() error
+	Reconcile() (*ReconcileReport, error)
+	AddHistoryEntry(userID string, entry *HistoryEntry, retention time.Duration) error
+	GetHistory(userID string, retention time.Duration) ([]*HistoryEntry, error)
+	GetPreferences(userID string) (*UserPreferences, error)
+	SavePreferences(userID string, preferences *UserPreferences) error
 
-	AddReference(remindDate int64, issueID string) error
-	RemoveReference(issueID string) error
+	AddDueReference(userID string, remindDate int64, issueID string) error
+	RemoveDueReference(userID string, remindDate int64, issueID string) error
+	AddFailedReference(issueID string) error
+	RemoveFailedReference(issueID string) error
+	AddUserReference(userID string, remindDate int64, issueID string) error
+	RemoveUserReference(userID, issueID string) error
 }
 
 type listManager struct {
 	store ListStore
+	queue *reminderQueue
 	api   plugin.API
 }
 
 // NewListManager creates a new listManager
-func NewListManager(api plugin.API) ListManager {
+func NewListManager(api plugin.API, queue *reminderQueue) ListManager {
 	return &listManager{
 		store: NewListStore(api),
+		queue: queue,
 		api:   api,
 	}
 }
 
-func (l *listManager) AddIssue(userID, message, postID string, when int64) (*Reminder, error) {
-	issue := newReminder(userID, message, postID, when)
+// LoadQueue fills the in-memory queue with the references kept in the store.
+func (l *listManager) LoadQueue() error {
+	refs, err := l.store.GetDueList()
+	if err != nil {
+		return err
+	}
+
+	l.queue.Reset(refs)
+	return nil
+}
+
+// ReloadQueue adds to the queue the references of the due index from since to
+// until, to pick up the reminders added or moved by other nodes since the last load.
+func (l *listManager) ReloadQueue(since, until int64) error {
+	refs, err := l.store.GetDueListBetween(since, until)
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		l.queue.Push(ref)
+	}
+	return nil
+}
+
+// AddIssue stores a new issue, made by newReminder, about a post its owner can read.
+func (l *listManager) AddIssue(issue *Reminder) error {
+	if !canReadPost(l.api, issue.CreateBy, issue.PostID) {
+		return errPostNotReadable
+	}
+	if post, appErr := l.api.GetPost(issue.PostID); appErr == nil {
+		issue.PostMessage = post.Message
+	}
+
+	if issue.Recurrence != nil {
+		issue.Recurrence.Start = issue.When
+		issue.Recurrence.Count = 0
+	}
 
 	if err := l.store.AddReminder(issue); err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := l.store.AddReference(issue.When, issue.ID); err != nil {
+	if err := l.store.AddDueReference(issue.CreateBy, issue.When, issue.ID); err != nil {
 		if rollbackError := l.store.RemoveReminder(issue.ID); rollbackError != nil {
 			l.api.LogError("cannot rollback issue after add error, Err=", err.Error())
 		}
-		return nil, err
+		return err
 	}
 
-	return issue, nil
+	if err := l.store.AddUserReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+		l.api.LogError("cannot add issue to the user list, Err=", err.Error())
+	}
+
+	l.queue.Push(&ReminderRef{ReminderID: issue.ID, ReminderDate: issue.When})
+
+	return nil
+}
+
+func (l *listManager) GetIssue(issueID string) (*Reminder, error) {
+	return l.store.GetReminder(issueID)
 }
 
 func (l *listManager) RemoveIssue(issueID string) (outIssue *Reminder, outErr error) {
@@ -55,9 +124,18 @@ func (l *listManager) RemoveIssue(issueID string) (outIssue *Reminder, outErr er
 		return nil, fmt.Errorf("cannot find element")
 	}
 
-	if err := l.store.RemoveReference(issueID); err != nil {
+	if ir.State == ReminderStateFailed {
+		if err := l.store.RemoveFailedReference(issueID); err != nil {
+			return nil, err
+		}
+	} else if err := l.store.RemoveDueReference(ir.CreateBy, ir.When, issueID); err != nil {
 		return nil, err
 	}
+	l.queue.Remove(issueID)
+
+	if err := l.store.RemoveUserReference(ir.CreateBy, issueID); err != nil {
+		l.api.LogError("cannot remove issue from the user list, Err=", err.Error())
+	}
 
 	issue, err := l.store.GetAndRemoveReminder(issueID)
 	if err != nil {
@@ -67,20 +145,101 @@ func (l *listManager) RemoveIssue(issueID string) (outIssue *Reminder, outErr er
 	return issue, nil
 }
 
-func (l *listManager) GetActiveIssues() ([]*Reminder, error) {
-	refs, err := l.store.GetList()
+// UpdateIssue stores the changes of an issue that keep its date and its state.
+func (l *listManager) UpdateIssue(issue *Reminder) error {
+	return l.store.AddReminder(issue)
+}
+
+// RescheduleIssue stores the updated issue and moves its reference to the given date.
+// A failed issue is moved back to the pending list.
+func (l *listManager) RescheduleIssue(issue *Reminder, when int64) error {
+	failed := issue.State == ReminderStateFailed
+	previous := issue.When
+	if failed {
+		issue.State = ""
+		issue.Attempts = 0
+		issue.LastError = ""
+	}
+
+	issue.When = when
+	if err := l.store.AddReminder(issue); err != nil {
+		return err
+	}
+
+	if failed {
+		if err := l.store.RemoveFailedReference(issue.ID); err != nil {
+			return err
+		}
+	} else if err := l.store.RemoveDueReference(issue.CreateBy, previous, issue.ID); err != nil {
+		return err
+	}
+	if err := l.store.AddDueReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+		return err
+	}
+
+	if err := l.store.RemoveUserReference(issue.CreateBy, issue.ID); err != nil {
+		l.api.LogError("cannot remove issue from the user list, Err=", err.Error())
+	}
+	if err := l.store.AddUserReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+		l.api.LogError("cannot add issue to the user list, Err=", err.Error())
+	}
+
+	l.queue.Push(&ReminderRef{ReminderID: issue.ID, ReminderDate: issue.When})
+	return nil
+}
+
+// FailIssue moves an issue that cannot be delivered out of the pending list and
+// into the list of failed issues, where it is kept until it is dismissed.
+func (l *listManager) FailIssue(issue *Reminder) error {
+	issue.State = ReminderStateFailed
+	if err := l.store.AddReminder(issue); err != nil {
+		return err
+	}
+
+	if err := l.store.AddFailedReference(issue.ID); err != nil {
+		return err
+	}
+
+	if err := l.store.RemoveDueReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+		l.api.LogError("cannot remove failed issue from list, Err=", err.Error())
+	}
+	if err := l.store.RemoveUserReference(issue.CreateBy, issue.ID); err != nil {
+		l.api.LogError("cannot remove failed issue from the user list, Err=", err.Error())
+	}
+	l.queue.Remove(issue.ID)
+
+	return nil
+}
+
+// GetFailedIssues returns the failed issues created by the given user, or every
+// failed issue when userID is empty.
+func (l *listManager) GetFailedIssues(userID string) ([]*Reminder, error) {
+	refs, err := l.store.GetFailedList()
 	if err != nil {
 		return nil, err
 	}
 
-	now := model.GetMillis()
-
 	reminders := []*Reminder{}
 	for _, ref := range refs {
-		if ref.ReminderDate > now {
+		reminder, err := l.store.GetReminder(ref.ReminderID)
+		if err != nil {
+			continue
+		}
+		if userID != "" && reminder.CreateBy != userID {
 			continue
 		}
 
+		reminders = append(reminders, reminder)
+	}
+
+	return reminders, nil
+}
+
+func (l *listManager) GetActiveIssues() ([]*Reminder, error) {
+	refs := l.queue.PopDue(model.GetMillis())
+
+	reminders := []*Reminder{}
+	for _, ref := range refs {
 		reminder, err := l.store.GetReminder(ref.ReminderID)
 		if err != nil {
 			continue
@@ -92,6 +251,128 @@ func (l *listManager) GetActiveIssues() ([]*Reminder, error) {
 	return reminders, nil
 }
 
+// GetUserIssues returns the pending issues created by the given user, earliest first.
+func (l *listManager) GetUserIssues(userID string) ([]*Reminder, error) {
+	refs, err := l.getUserRefs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.readUserIssues(userID, refs), nil
+}
+
+// GetUserIssuesPage returns a page of the pending issues created by the given user,
+// earliest first, and the number of these issues. Only the issues of the page are read.
+func (l *listManager) GetUserIssuesPage(userID string, page, perPage int) ([]*Reminder, int, error) {
+	refs, err := l.getUserRefs(userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(refs)
+	if page > total || page*perPage >= total {
+		return []*Reminder{}, total, nil
+	}
+	refs = refs[page*perPage:]
+	if len(refs) > perPage {
+		refs = refs[:perPage]
+	}
+
+	return l.readUserIssues(userID, refs), total, nil
+}
+
+// getUserRefs returns the references of the per-user index, earliest first.
+func (l *listManager) getUserRefs(userID string) ([]*ReminderRef, error) {
+	refs, err := l.store.GetUserList(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].ReminderDate < refs[j].ReminderDate
+	})
+	return refs, nil
+}
+
+func (l *listManager) readUserIssues(userID string, refs []*ReminderRef) []*Reminder {
+	reminders := []*Reminder{}
+	for _, ref := range refs {
+		reminder, err := l.store.GetReminder(ref.ReminderID)
+		if err != nil || reminder.CreateBy != userID {
+			continue
+		}
+
+		reminders = append(reminders, reminder)
+	}
+
+	return reminders
+}
+
+// CountUserIssues returns the number of pending issues created by the given user,
+// without reading them.
+func (l *listManager) CountUserIssues(userID string) (int, error) {
+	refs, err := l.store.GetUserList(userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(refs), nil
+}
+
+// GetUsersWithIssues returns the users who created issues.
+func (l *listManager) GetUsersWithIssues() ([]string, error) {
+	return l.store.GetUserIDs()
+}
+
+// AddAwayEntry keeps a reminder due while the user is out of office.
+func (l *listManager) AddAwayEntry(userID string, entry *AwayEntry) error {
+	return l.store.AddAwayEntry(userID, entry)
+}
+
+// GetAwayEntries returns the reminders due while the user was out of office.
+func (l *listManager) GetAwayEntries(userID string) ([]*AwayEntry, error) {
+	return l.store.GetAwayEntries(userID)
+}
+
+// RemoveAwayEntries forgets the count oldest reminders due while the user was out of office.
+func (l *listManager) RemoveAwayEntries(userID string, count int) error {
+	return l.store.RemoveAwayEntries(userID, count)
+}
+
+// GetAwayUserIDs returns the users with reminders due while they were out of office.
+func (l *listManager) GetAwayUserIDs() ([]string, error) {
+	return l.store.GetAwayUserIDs()
+}
+
+// AddHistoryEntry records an event in the history of the user.
+func (l *listManager) AddHistoryEntry(userID string, entry *HistoryEntry, retention time.Duration) error {
+	return l.store.AddHistoryEntry(userID, entry, retention)
+}
+
+// GetHistory returns the history of the user, oldest first.
+func (l *listManager) GetHistory(userID string, retention time.Duration) ([]*HistoryEntry, error) {
+	return l.store.GetHistory(userID, retention)
+}
+
+// GetPreferences returns the preferences of the user.
+func (l *listManager) GetPreferences(userID string) (*UserPreferences, error) {
+	return l.store.GetPreferences(userID)
+}
+
+// SavePreferences stores the preferences of the user.
+func (l *listManager) SavePreferences(userID string, preferences *UserPreferences) error {
+	return l.store.SavePreferences(userID, preferences)
+}
+
+// Reconcile repairs the references of the store that do not match its issues.
+func (l *listManager) Reconcile() (*ReconcileReport, error) {
+	return l.store.Reconcile()
+}
+
+// Migrate brings the data in the store to the current schema version.
+func (l *listManager) Migrate() error {
+	return l.store.Migrate()
+}
+
 func (l *listManager) GetUserName(userID string) string {
 	user, err := l.api.GetUser(userID)
 	if err != nil {
@@ -34,7 +34,86 @@ const manifestStr = `
   "settings_schema": {
     "header": "",
     "footer": "",
-    "settings": []
+    "settings": [
+      {
+        "key": "HistoryRetentionDays",
+        "display_name": "History retention (days):",
+        "type": "number",
+        "help_text": "The number of days fired, snoozed, done and failed reminders are kept in the history of their owner.",
+        "placeholder": "",
+        "default": 30
+      },
+      {
+        "key": "AccessLostPolicy",
+        "display_name": "Reminders of users who lost access:",
+        "type": "radio",
+        "help_text": "What happens to the reminders of users who left the channel or team of their post, or were deactivated. Pause moves them to the failed reminders, from which they can be snoozed again. Cancel removes them. Notify tells the users right away and keeps the reminders, which fail when due unless the users got access again.",
+        "placeholder": "",
+        "default": "pause",
+        "options": [
+          {
+            "display_name": "Pause",
+            "value": "pause"
+          },
+          {
+            "display_name": "Cancel",
+            "value": "cancel"
+          },
+          {
+            "display_name": "Notify",
+            "value": "notify"
+          }
+        ]
+      },
+      {
+        "key": "SchedulerIntervalSeconds",
+        "display_name": "Scheduler interval (seconds):",
+        "type": "number",
+        "help_text": "How often the scheduler reloads the pending reminders, to pick up those added on other servers of a cluster. Between 5 and 3600 seconds.",
+        "placeholder": "",
+        "default": 60
+      },
+      {
+        "key": "MaxPendingReminders",
+        "display_name": "Maximum pending reminders per user:",
+        "type": "number",
+        "help_text": "The number of pending reminders a user can have. 0 for no limit.",
+        "placeholder": "",
+        "default": 500
+      },
+      {
+        "key": "MaxHorizonDays",
+        "display_name": "Maximum reminder horizon (days):",
+        "type": "number",
+        "help_text": "How many days ahead a reminder can be set. 0 for no limit.",
+        "placeholder": "",
+        "default": 365
+      },
+      {
+        "key": "MaxMessageLength",
+        "display_name": "Maximum message length:",
+        "type": "number",
+        "help_text": "The number of characters of a reminder message. 0 for no limit.",
+        "placeholder": "",
+        "default": 1000
+      },
+      {
+        "key": "SnoozeDurations",
+        "display_name": "Snooze durations:",
+        "type": "text",
+        "help_text": "The snooze buttons of a delivered reminder, as up to 5 comma separated durations in whole minutes, like 20m,1h,4h.",
+        "placeholder": "",
+        "default": "20m,1h"
+      },
+      {
+        "key": "AllowedDeliveryModes",
+        "display_name": "Allowed delivery modes:",
+        "type": "text",
+        "help_text": "The comma separated delivery modes users can choose among dm, thread and ephemeral. All of them when empty. Direct messages are always allowed, since the other modes fall back to them.",
+        "placeholder": "",
+        "default": "dm,thread,ephemeral"
+      }
+    ]
   }
 }
 `
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// migrationLease bounds how long a node may hold the migration lock.
+	migrationLease = 10 * time.Minute
+	// migrationRetryInterval is how long a node waits before trying the migration lock again.
+	migrationRetryInterval = time.Second
+)
+
+// migration moves the data of the KV store from the previous schema version to
+// version. Migrations must be idempotent: one that fails half way is run again on
+// the next activation.
+type migration struct {
+	version int
+	name    string
+	run     func(l *listStore) error
+}
+
+// migrations lists every migration, ordered by version. New ones are appended.
+var migrations = []migration{
+	{version: 1, name: "dated global list", run: migrateDatedList},
+	{version: 2, name: "per-user lists", run: migrateUserLists},
+	{version: 3, name: "due index", run: migrateDueIndex},
+}
+
+// Migrate runs the migrations newer than the schema version of the store, in order,
+// and records the version after each of them.
+func (l *listStore) Migrate() error {
+	current, err := l.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+
+		l.api.LogInfo("Migrating the KV store.", "version", m.version, "migration", m.name)
+		if err := m.run(l); err != nil {
+			return errors.Wrapf(err, "migration %d (%s) failed", m.version, m.name)
+		}
+		if err := l.setSchemaVersion(m.version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *listStore) getSchemaVersion() (int, error) {
+	value, appErr := l.api.KVGet(StoreSchemaVersionKey)
+	if appErr != nil {
+		return 0, errors.New(appErr.Error())
+	}
+	if value == nil {
+		return 0, nil
+	}
+
+	return strconv.Atoi(string(value))
+}
+
+func (l *listStore) setSchemaVersion(version int) error {
+	if appErr := l.api.KVSet(StoreSchemaVersionKey, []byte(strconv.Itoa(version))); appErr != nil {
+		return errors.New(appErr.Error())
+	}
+	return nil
+}
+
+// migrateDatedList converts the global list of the first versions, a list of issue
+// IDs, to a list of references, and dates the references from their issues.
+func migrateDatedList(l *listStore) error {
+	for i := 0; i < StoreRetries; i++ {
+		originalJSONList, appErr := l.api.KVGet(listKey())
+		if appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		if originalJSONList == nil {
+			return nil
+		}
+
+		var list []*ReminderRef
+		if err := json.Unmarshal(originalJSONList, &list); err != nil {
+			var ids []string
+			if err := json.Unmarshal(originalJSONList, &ids); err != nil {
+				return err
+			}
+
+			list = []*ReminderRef{}
+			for _, id := range ids {
+				list = append(list, &ReminderRef{ReminderID: id})
+			}
+		}
+
+		dated := []*ReminderRef{}
+		for _, ref := range list {
+			if ref.ReminderDate == 0 {
+				issue, err := l.GetReminder(ref.ReminderID)
+				if err != nil {
+					// The issue is gone, so is its reference.
+					continue
+				}
+				ref.ReminderDate = issue.When
+			}
+			dated = append(dated, ref)
+		}
+
+		ok, err := l.saveList(listKey(), dated, originalJSONList)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("unable to store list")
+}
+
+// migrateUserLists adds the reminders of the global list to the per-user lists.
+func migrateUserLists(l *listStore) error {
+	refs, err := l.GetList()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		issue, err := l.GetReminder(ref.ReminderID)
+		if err != nil {
+			continue
+		}
+
+		userRefs, err := l.GetUserList(issue.CreateBy)
+		if err != nil {
+			return err
+		}
+		if containsReference(userRefs, issue.ID) {
+			continue
+		}
+
+		if err := l.AddUserReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// migrateDueIndex moves the reminders of the global list to the due index and
+// deletes the global list.
+func migrateDueIndex(l *listStore) error {
+	refs, err := l.GetList()
+	if err != nil {
+		return err
+	}
+
+	for _, ref := range refs {
+		issue, err := l.GetReminder(ref.ReminderID)
+		if err != nil || issue.State == ReminderStateFailed {
+			continue
+		}
+
+		dueRefs, _, err := l.getList(dueKey(issue.CreateBy, issue.When))
+		if err != nil {
+			return err
+		}
+		if containsReference(dueRefs, issue.ID) {
+			continue
+		}
+
+		if err := l.AddDueReference(issue.CreateBy, issue.When, issue.ID); err != nil {
+			return err
+		}
+	}
+
+	if appErr := l.api.KVDelete(listKey()); appErr != nil {
+		return errors.New(appErr.Error())
+	}
+	return nil
+}
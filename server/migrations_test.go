@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	first := &Reminder{ID: model.NewId(), CreateBy: model.NewId(), When: 1791972000000}
+	second := &Reminder{ID: model.NewId(), CreateBy: model.NewId(), When: 1792058400000}
+
+	kv := map[string][]byte{}
+	for _, reminder := range []*Reminder{first, second} {
+		value, err := json.Marshal(reminder)
+		require.NoError(t, err)
+		kv[issueKey(reminder.ID)] = value
+	}
+	// The first versions kept a list of IDs, one of them without issue.
+	legacyList, err := json.Marshal([]string{first.ID, second.ID, model.NewId()})
+	require.NoError(t, err)
+	kv[listKey()] = legacyList
+
+	store := &listStore{api: newMemoryKVAPI(kv)}
+	require.NoError(t, store.Migrate())
+
+	assert.Equal(t, "3", string(kv[StoreSchemaVersionKey]))
+	assert.NotContains(t, kv, listKey())
+
+	due, err := store.GetDueList()
+	require.NoError(t, err)
+	sort.Slice(due, func(i, j int) bool { return due[i].ReminderDate < due[j].ReminderDate })
+	assert.Equal(t, []*ReminderRef{
+		{ReminderID: first.ID, ReminderDate: first.When},
+		{ReminderID: second.ID, ReminderDate: second.When},
+	}, due)
+
+	userRefs, err := store.GetUserList(first.CreateBy)
+	require.NoError(t, err)
+	assert.Equal(t, []*ReminderRef{{ReminderID: first.ID, ReminderDate: first.When}}, userRefs)
+
+	// Running again changes nothing.
+	before := len(kv)
+	require.NoError(t, store.Migrate())
+	assert.Len(t, kv, before)
+
+	// Every migration can be run again after it succeeded.
+	for _, m := range migrations {
+		require.NoError(t, m.run(store), m.name)
+	}
+	due, err = store.GetDueList()
+	require.NoError(t, err)
+	assert.Len(t, due, 2)
+}
+
+func TestMigrateWaitsForTheLock(t *testing.T) {
+	kv := map[string][]byte{}
+	// Another node holds the lock on the first try.
+	api := &plugintest.API{}
+	api.On("KVSetWithOptions", lockKey("migrations"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(false, nil).Once()
+	api.ExpectedCalls = append(api.ExpectedCalls, newMemoryKVAPI(kv).ExpectedCalls...)
+	p := newTestPlugin(api)
+
+	require.NoError(t, p.migrate())
+	assert.Equal(t, strconv.Itoa(migrations[len(migrations)-1].version), string(kv[StoreSchemaVersionKey]))
+	assert.NotContains(t, kv, lockKey("migrations"))
+	api.AssertNumberOfCalls(t, "KVSetWithOptions", 2)
+}
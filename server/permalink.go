@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// postPermalink returns the link to the post for the user. Links are scoped to the
+// team of the channel, or for direct and group messages to a team of the user, the
+// last one they viewed first. The site redirects the team-agnostic link, used when
+// no team fits, to a team of the user.
+func (p *Plugin) postPermalink(userID string, post *model.Post, channel *model.Channel) string {
+	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
+
+	teamName := ""
+	if channel.IsGroupOrDirect() {
+		teamName = p.userTeamName(userID)
+	} else if team, appErr := p.API.GetTeam(channel.TeamId); appErr == nil {
+		teamName = team.Name
+	} else {
+		p.API.LogError("Unable to get the team of the channel err=" + appErr.Error())
+	}
+
+	return permalinkURL(siteURL, teamName, post.Id)
+}
+
+// permalinkURL returns the link to the post in the team, or the team-agnostic link
+// without a team name.
+func permalinkURL(siteURL, teamName, postID string) string {
+	if teamName == "" {
+		return fmt.Sprintf("%s/_redirect/pl/%s", siteURL, postID)
+	}
+	return fmt.Sprintf("%s/%s/pl/%s", siteURL, teamName, postID)
+}
+
+// userTeamName returns the name of the team the user last viewed, or else of the
+// first of their teams by name. It returns an empty name if the user is in no team.
+func (p *Plugin) userTeamName(userID string) string {
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		p.API.LogError("Unable to get the teams of the user err=" + appErr.Error())
+		return ""
+	}
+	if len(teams) == 0 {
+		return ""
+	}
+
+	if preferences, appErr := p.API.GetPreferencesForUser(userID); appErr == nil {
+		for _, preference := range preferences {
+			if preference.Category != model.PREFERENCE_CATEGORY_LAST || preference.Name != model.PREFERENCE_NAME_LAST_TEAM {
+				continue
+			}
+			for _, team := range teams {
+				if team.Id == preference.Value {
+					return team.Name
+				}
+			}
+		}
+	}
+
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams[0].Name
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostPermalink(t *testing.T) {
+	siteURL := "https://chat.example.com"
+	post := &model.Post{Id: model.NewId()}
+	alpha := &model.Team{Id: model.NewId(), Name: "alpha"}
+	beta := &model.Team{Id: model.NewId(), Name: "beta"}
+	directChannel := &model.Channel{Id: model.NewId(), Type: model.CHANNEL_DIRECT}
+	teamChannel := &model.Channel{Id: model.NewId(), TeamId: beta.Id, Type: model.CHANNEL_OPEN}
+
+	lastViewer := model.NewId()
+	otherUser := model.NewId()
+	teamless := model.NewId()
+
+	api := &plugintest.API{}
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("GetTeam", beta.Id).Return(beta, nil)
+	api.On("GetTeamsForUser", lastViewer).Return([]*model.Team{alpha, beta}, nil)
+	api.On("GetTeamsForUser", otherUser).Return([]*model.Team{beta, alpha}, nil)
+	api.On("GetTeamsForUser", teamless).Return([]*model.Team{}, nil)
+	api.On("GetPreferencesForUser", lastViewer).Return([]model.Preference{
+		{UserId: lastViewer, Category: model.PREFERENCE_CATEGORY_LAST, Name: model.PREFERENCE_NAME_LAST_TEAM, Value: beta.Id},
+	}, nil)
+	api.On("GetPreferencesForUser", otherUser).Return([]model.Preference{}, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+
+	assert.Equal(t, siteURL+"/beta/pl/"+post.Id, p.postPermalink(teamless, post, teamChannel), "team of the channel")
+	assert.Equal(t, siteURL+"/beta/pl/"+post.Id, p.postPermalink(lastViewer, post, directChannel), "last viewed team")
+	assert.Equal(t, siteURL+"/alpha/pl/"+post.Id, p.postPermalink(otherUser, post, directChannel), "first team by name")
+	assert.Equal(t, siteURL+"/_redirect/pl/"+post.Id, p.postPermalink(teamless, post, directChannel), "no team")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+)
+
+// errPostNotReadable is returned for posts that do not exist and for posts the user
+// cannot read alike, so that the existence of a post is not disclosed.
+var errPostNotReadable = errors.New("cannot find the post")
+
+// canReadChannel tells whether the user can read the posts of the channel, either
+// as a member or, for public channels, as a member of its team.
+func canReadChannel(api plugin.API, userID string, channel *model.Channel) bool {
+	if api.HasPermissionToChannel(userID, channel.Id, model.PERMISSION_READ_CHANNEL) {
+		return true
+	}
+
+	return channel.Type == model.CHANNEL_OPEN && api.HasPermissionToTeam(userID, channel.TeamId, model.PERMISSION_READ_PUBLIC_CHANNEL)
+}
+
+// canReadPost tells whether the post exists and the user can read it.
+func canReadPost(api plugin.API, userID, postID string) bool {
+	if !model.IsValidId(postID) {
+		return false
+	}
+
+	post, appErr := api.GetPost(postID)
+	if appErr != nil {
+		return false
+	}
+
+	channel, appErr := api.GetChannel(post.ChannelId)
+	if appErr != nil {
+		return false
+	}
+
+	return canReadChannel(api, userID, channel)
+}
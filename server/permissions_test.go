@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanReadPost(t *testing.T) {
+	userID := model.NewId()
+	openChannel := &model.Channel{Id: model.NewId(), TeamId: model.NewId(), Type: model.CHANNEL_OPEN}
+	privateChannel := &model.Channel{Id: model.NewId(), TeamId: model.NewId(), Type: model.CHANNEL_PRIVATE}
+	openPost := &model.Post{Id: model.NewId(), ChannelId: openChannel.Id}
+	privatePost := &model.Post{Id: model.NewId(), ChannelId: privateChannel.Id}
+	missingPostID := model.NewId()
+
+	api := &plugintest.API{}
+	api.On("GetPost", openPost.Id).Return(openPost, nil)
+	api.On("GetPost", privatePost.Id).Return(privatePost, nil)
+	api.On("GetPost", missingPostID).Return(nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound))
+	api.On("GetChannel", openChannel.Id).Return(openChannel, nil)
+	api.On("GetChannel", privateChannel.Id).Return(privateChannel, nil)
+	api.On("HasPermissionToChannel", userID, openChannel.Id, model.PERMISSION_READ_CHANNEL).Return(false)
+	api.On("HasPermissionToChannel", userID, privateChannel.Id, model.PERMISSION_READ_CHANNEL).Return(false)
+	api.On("HasPermissionToTeam", userID, openChannel.TeamId, model.PERMISSION_READ_PUBLIC_CHANNEL).Return(true)
+	defer api.AssertExpectations(t)
+
+	assert.True(t, canReadPost(api, userID, openPost.Id), "public channel of the team")
+	assert.False(t, canReadPost(api, userID, privatePost.Id), "private channel without membership")
+	assert.False(t, canReadPost(api, userID, missingPostID), "missing post")
+	assert.False(t, canReadPost(api, userID, "not-an-id"), "invalid ID")
+}
@@ -3,8 +3,9 @@ package main
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
@@ -13,10 +14,31 @@ import (
 
 // ListManager represents the logic on the lists
 type ListManager interface {
-	AddIssue(userID, message, postID string, when int64) (*Reminder, error)
+	AddIssue(issue *Reminder) error
 	GetActiveIssues() ([]*Reminder, error)
+	GetIssue(issueID string) (*Reminder, error)
+	GetFailedIssues(userID string) ([]*Reminder, error)
+	GetUserIssues(userID string) ([]*Reminder, error)
+	GetUserIssuesPage(userID string, page, perPage int) ([]*Reminder, int, error)
+	CountUserIssues(userID string) (int, error)
+	GetUsersWithIssues() ([]string, error)
+	AddAwayEntry(userID string, entry *AwayEntry) error
+	GetAwayEntries(userID string) ([]*AwayEntry, error)
+	RemoveAwayEntries(userID string, count int) error
+	GetAwayUserIDs() ([]string, error)
+	Migrate() error
+	Reconcile() (*ReconcileReport, error)
+	AddHistoryEntry(userID string, entry *HistoryEntry, retention time.Duration) error
+	GetHistory(userID string, retention time.Duration) ([]*HistoryEntry, error)
+	GetPreferences(userID string) (*UserPreferences, error)
+	SavePreferences(userID string, preferences *UserPreferences) error
+	LoadQueue() error
+	ReloadQueue(since, until int64) error
 	GetUserName(userID string) string
 	RemoveIssue(issueID string) (*Reminder, error)
+	UpdateIssue(issue *Reminder) error
+	RescheduleIssue(issue *Reminder, when int64) error
+	FailIssue(issue *Reminder) error
 }
 
 // Plugin implements the interface expected by the Mattermost server to communicate between the server and plugin processes.
@@ -25,8 +47,6 @@ type Plugin struct {
 
 	BotUserID string
 
-	running bool
-
 	// configurationLock synchronizes access to the configuration.
 	configurationLock sync.RWMutex
 
@@ -35,6 +55,17 @@ type Plugin struct {
 	configuration *configuration
 
 	listManager ListManager
+
+	// queue holds the pending reminders ordered by due date for the scheduler.
+	queue *reminderQueue
+
+	scheduler *scheduler
+
+	// reconciler periodically repairs the references of the KV store.
+	reconciler *periodicJob
+
+	// awayChecker periodically sums up the reminders due while users were out of office.
+	awayChecker *periodicJob
 }
 
 func (p *Plugin) OnActivate() error {
@@ -55,64 +86,103 @@ func (p *Plugin) OnActivate() error {
 	}
 	p.BotUserID = botID
 
-	p.Run()
+	if err := p.API.RegisterCommand(getCommand()); err != nil {
+		return errors.Wrap(err, "failed to register command")
+	}
+
+	p.stopJobs()
+
+	p.queue = newReminderQueue()
+	p.listManager = NewListManager(p.API, p.queue)
+	if err := p.migrate(); err != nil {
+		return errors.Wrap(err, "failed to migrate the KV store")
+	}
+	if err := p.listManager.LoadQueue(); err != nil {
+		return errors.Wrap(err, "failed to load pending reminders")
+	}
 
-	p.listManager = NewListManager(p.API)
+	p.scheduler = newScheduler(p.queue, p.TriggerReminders, func(since, until time.Time) error {
+		return p.listManager.ReloadQueue(model.GetMillisForTime(since), model.GetMillisForTime(until))
+	}, func() time.Duration {
+		return p.getConfiguration().schedulerInterval()
+	}, p.API.LogError)
+	p.scheduler.Start()
+	p.reconciler = newPeriodicJob(reconcileInterval, p.reconcileStore)
+	p.reconciler.Start()
+	p.awayChecker = newPeriodicJob(awayCheckInterval, p.sendAwaySummaries)
+	p.awayChecker.Start()
 
 	return nil
 }
 
+// migrate runs the migrations of the KV store on a single node of the cluster. The
+// other nodes wait for the lock, so that they do not load the queue from a store
+// being migrated, and then find the schema up to date.
+func (p *Plugin) migrate() error {
+	mutex := newClusterMutex(p.API, lockKey("migrations"))
+	deadline := time.Now().Add(migrationLease)
+	for {
+		locked, err := mutex.TryLock(migrationLease)
+		if err != nil {
+			return err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("another node is still migrating the KV store")
+		}
+		time.Sleep(migrationRetryInterval)
+	}
+	defer func() { _ = mutex.Unlock() }()
+
+	return p.listManager.Migrate()
+}
+
 func (p *Plugin) OnDeactivate() error {
+	p.stopJobs()
 	return nil
 }
 
+// stopJobs stops the background goroutines of the plugin, if they are running.
+func (p *Plugin) stopJobs() {
+	if p.scheduler != nil {
+		p.scheduler.Stop()
+	}
+	if p.reconciler != nil {
+		p.reconciler.Stop()
+	}
+	if p.awayChecker != nil {
+		p.awayChecker.Stop()
+	}
+}
+
 // ServeHTTP demonstrates a plugin that handles HTTP requests by greeting the world.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/add":
 		p.handleAdd(w, r)
+	case "/failed":
+		p.handleFailed(w, r)
+	case "/action":
+		p.handleAction(w, r)
+	case "/reminders":
+		p.handleListReminders(w, r)
+	case "/reminders/history":
+		p.handleHistory(w, r)
+	case "/preferences":
+		p.handlePreferences(w, r)
+	case "/autocomplete/reminders":
+		p.handleAutocompleteReminders(w, r)
 	default:
+		if strings.HasPrefix(r.URL.Path, "/reminders/") {
+			p.handleReminder(w, r)
+			return
+		}
 		http.NotFound(w, r)
 	}
 }
 
-type addAPIRequest struct {
-	Message    string `json:"message"`
-	RememberAt string `json:"remember_at"`
-	PostID     string `json:"post_id"`
-}
-
-func (p *Plugin) handleAdd(w http.ResponseWriter, r *http.Request) {
-	userID := r.Header.Get("Mattermost-User-ID")
-	if userID == "" {
-		http.Error(w, "Not authorized", http.StatusUnauthorized)
-		return
-	}
-
-	var addRequest *addAPIRequest
-	decoder := json.NewDecoder(r.Body)
-	err := decoder.Decode(&addRequest)
-	if err != nil {
-		p.API.LogError("Unable to decode JSON err=" + err.Error())
-		p.handleErrorWithCode(w, http.StatusBadRequest, "Unable to decode JSON", err)
-		return
-	}
-
-	var time, convErr = strconv.ParseInt(addRequest.RememberAt, 10, 64)
-	if convErr != nil {
-		p.API.LogError("Unable to add issue err=" + convErr.Error())
-		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to add issue", convErr)
-		return
-	}
-
-	_, err = p.listManager.AddIssue(userID, addRequest.Message, addRequest.PostID, time)
-	if err != nil {
-		p.API.LogError("Unable to add issue err=" + err.Error())
-		p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to add issue", err)
-		return
-	}
-}
-
 func (p *Plugin) handleErrorWithCode(w http.ResponseWriter, code int, errTitle string, err error) {
 	w.WriteHeader(code)
 	b, _ := json.Marshal(struct {
@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// maxDiffLines bounds the number of lines compared by lineDiff, above which the
+// edit is shown as the removal of every old line and the addition of every new one.
+const maxDiffLines = 200
+
+// lineDiff describes how a message was edited, line by line, in the format of a
+// unified diff without context headers: removed lines start with "-", added lines
+// with "+" and unchanged lines with a space.
+func lineDiff(oldText, newText string) string {
+	oldLines := strings.Split(oldText, "\n")
+	newLines := strings.Split(newText, "\n")
+
+	if len(oldLines) > maxDiffLines || len(newLines) > maxDiffLines {
+		diff := make([]string, 0, len(oldLines)+len(newLines))
+		for _, line := range oldLines {
+			diff = append(diff, "-"+line)
+		}
+		for _, line := range newLines {
+			diff = append(diff, "+"+line)
+		}
+		return strings.Join(diff, "\n")
+	}
+
+	// common[i][j] is the length of the longest common subsequence of
+	// oldLines[i:] and newLines[j:].
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			diff = append(diff, " "+oldLines[i])
+			i++
+			j++
+		case j == len(newLines) || (i < len(oldLines) && common[i+1][j] >= common[i][j+1]):
+			diff = append(diff, "-"+oldLines[i])
+			i++
+		default:
+			diff = append(diff, "+"+newLines[j])
+			j++
+		}
+	}
+
+	return strings.Join(diff, "\n")
+}
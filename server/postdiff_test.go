@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineDiff(t *testing.T) {
+	assert.Equal(t, " same", lineDiff("same", "same"))
+	assert.Equal(t, "-old\n+new", lineDiff("old", "new"))
+	assert.Equal(t,
+		" Release checklist\n-- tag v1.2\n+- tag v1.3\n+- announce it\n done",
+		lineDiff("Release checklist\n- tag v1.2\ndone", "Release checklist\n- tag v1.3\n- announce it\ndone"),
+	)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// StorePreferencesKey is the key prefix used to store the preferences of a user.
+const StorePreferencesKey = "prefs"
+
+// UserPreferences are the reminder settings of a user.
+type UserPreferences struct {
+	// DeliveryMode is how reminders without a mode of their own are delivered.
+	DeliveryMode string `json:"delivery_mode,omitempty"`
+	// WorkingHours, if set, are when reminders set to be delivered at working time
+	// are delivered.
+	WorkingHours *WorkingHours `json:"working_hours,omitempty"`
+	// DoNotDisturb is how reminders reach the user in Do Not Disturb: deliver, the
+	// default, hold or silent.
+	DoNotDisturb string `json:"do_not_disturb,omitempty"`
+	// OutOfOffice is how reminders reach the user out of office: defer, the default,
+	// or deliver.
+	OutOfOffice string `json:"out_of_office,omitempty"`
+}
+
+// IsValid checks the preferences.
+func (u *UserPreferences) IsValid() error {
+	if u.DeliveryMode != "" && !isDeliveryMode(u.DeliveryMode) {
+		return errors.Errorf("unknown delivery mode %q", u.DeliveryMode)
+	}
+	if u.DoNotDisturb != "" && !containsString(doNotDisturbModes, u.DoNotDisturb) {
+		return errors.Errorf("unknown do not disturb mode %q", u.DoNotDisturb)
+	}
+	if u.OutOfOffice != "" && !containsString(outOfOfficeModes, u.OutOfOffice) {
+		return errors.Errorf("unknown out of office mode %q", u.OutOfOffice)
+	}
+	if u.WorkingHours != nil {
+		return u.WorkingHours.IsValid()
+	}
+	return nil
+}
+
+func preferencesKey(userID string) string {
+	return fmt.Sprintf("%s_%s", StorePreferencesKey, userID)
+}
+
+// GetPreferences returns the preferences of the user, empty if none are saved.
+func (l *listStore) GetPreferences(userID string) (*UserPreferences, error) {
+	value, appErr := l.api.KVGet(preferencesKey(userID))
+	if appErr != nil {
+		return nil, errors.New(appErr.Error())
+	}
+
+	preferences := &UserPreferences{}
+	if value == nil {
+		return preferences, nil
+	}
+	if err := json.Unmarshal(value, preferences); err != nil {
+		return nil, err
+	}
+
+	return preferences, nil
+}
+
+// SavePreferences stores the preferences of the user.
+func (l *listStore) SavePreferences(userID string, preferences *UserPreferences) error {
+	value, err := json.Marshal(preferences)
+	if err != nil {
+		return err
+	}
+
+	if appErr := l.api.KVSet(preferencesKey(userID), value); appErr != nil {
+		return errors.New(appErr.Error())
+	}
+	return nil
+}
+
+// getUserPreferences returns the preferences of the user, or the defaults when they
+// cannot be read.
+func (p *Plugin) getUserPreferences(userID string) *UserPreferences {
+	preferences, err := p.listManager.GetPreferences(userID)
+	if err != nil {
+		p.API.LogError("Unable to get the user preferences. err=" + err.Error())
+		return &UserPreferences{}
+	}
+	return preferences
+}
+
+// handlePreferences returns the preferences of the user on GET, and replaces them on PUT.
+func (p *Plugin) handlePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		preferences, err := p.listManager.GetPreferences(userID)
+		if err != nil {
+			p.API.LogError("Unable to get the user preferences err=" + err.Error())
+			p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to get the preferences", err)
+			return
+		}
+		p.writeJSON(w, preferences)
+	case http.MethodPut:
+		var preferences *UserPreferences
+		if err := json.NewDecoder(r.Body).Decode(&preferences); err != nil || preferences == nil {
+			if err == nil {
+				err = errors.New("empty request")
+			}
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Unable to decode JSON", err)
+			return
+		}
+		if err := preferences.IsValid(); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid preferences", err)
+			return
+		}
+		if err := p.getConfiguration().checkDeliveryMode(preferences.DeliveryMode); err != nil {
+			p.handleErrorWithCode(w, http.StatusBadRequest, "Invalid preferences", err)
+			return
+		}
+
+		if err := p.listManager.SavePreferences(userID, preferences); err != nil {
+			p.API.LogError("Unable to save the user preferences err=" + err.Error())
+			p.handleErrorWithCode(w, http.StatusInternalServerError, "Unable to save the preferences", err)
+			return
+		}
+		p.writeJSON(w, preferences)
+	default:
+		p.handleErrorWithCode(w, http.StatusMethodNotAllowed, "Method not allowed", errors.Errorf("%s is not supported", r.Method))
+	}
+}
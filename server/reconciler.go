@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// StoreReconcileKey is the key of the inconsistencies found by the last run of the reconciler.
+	StoreReconcileKey = "reconcile_pending"
+
+	// reconcileInterval is the delay between two runs of the reconciler.
+	reconcileInterval = time.Hour
+	// reconcileLease bounds how long a node may hold the reconciler lock.
+	reconcileLease = 10 * time.Minute
+
+	repairAdd        = "add"
+	repairRemove     = "remove"
+	repairDeleteItem = "delete_item"
+)
+
+// repair is a fix of an inconsistency of the store: a reference added to or removed
+// from the list kept at Key, or an undecodable item deleted.
+type repair struct {
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	IssueID string `json:"issue_id"`
+	Date    int64  `json:"date,omitempty"`
+}
+
+func (r repair) id() string {
+	return fmt.Sprintf("%s:%s:%s:%d", r.Kind, r.Key, r.IssueID, r.Date)
+}
+
+// ReconcileReport tells what a run of the reconciler found and fixed.
+type ReconcileReport struct {
+	Items    int
+	Added    int
+	Removed  int
+	Deleted  int
+	Deferred int
+	Failed   int
+}
+
+func (r *ReconcileReport) String() string {
+	return fmt.Sprintf("checked %d items: %d references added, %d references removed, %d items deleted, %d fixes deferred, %d fixes failed",
+		r.Items, r.Added, r.Removed, r.Deleted, r.Deferred, r.Failed)
+}
+
+// Reconcile cross-checks the items of the store with the due index, the per-user
+// lists and the failed list. An inconsistency may be an operation in flight, so it
+// is only fixed when the previous run found it too.
+func (l *listStore) Reconcile() (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+
+	repairs, err := l.findRepairs(report)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := l.getPendingRepairs()
+	if err != nil {
+		return nil, err
+	}
+
+	deferred := []repair{}
+	for _, r := range repairs {
+		if !previous[r.id()] {
+			deferred = append(deferred, r)
+			report.Deferred++
+			continue
+		}
+
+		if err := l.applyRepair(r); err != nil {
+			l.api.LogWarn("Unable to repair the KV store.", "repair", r.id(), "err", err.Error())
+			report.Failed++
+			continue
+		}
+
+		switch r.Kind {
+		case repairAdd:
+			report.Added++
+		case repairRemove:
+			report.Removed++
+		case repairDeleteItem:
+			report.Deleted++
+		}
+	}
+
+	if err := l.setPendingRepairs(deferred); err != nil {
+		return nil, err
+	}
+
+	if err := l.syncDueBuckets(report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// syncDueBuckets adds the users of the shards of the due index to their bucket, as
+// a shard may have been restored by a repair. Unlike the other repairs, these are
+// safe to apply at once: an extra user in a bucket only costs a read.
+func (l *listStore) syncDueBuckets(report *ReconcileReport) error {
+	keys, err := l.listKeys(StoreDueKey + "_")
+	if err != nil {
+		return err
+	}
+
+	now := model.GetMillis()
+	listed := map[int64][]string{}
+	for _, key := range keys {
+		parts := strings.SplitN(strings.TrimPrefix(key, StoreDueKey+"_"), "_", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		bucket, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || bucket+(dueBucketSize+dueBucketRetention).Milliseconds() <= now {
+			continue
+		}
+
+		if _, ok := listed[bucket]; !ok {
+			if listed[bucket], err = l.getBucketUsers(bucket); err != nil {
+				return err
+			}
+		}
+		if containsString(listed[bucket], parts[1]) {
+			continue
+		}
+		if err := l.addBucketUser(bucket, parts[1]); err != nil {
+			return err
+		}
+		report.Added++
+	}
+
+	return nil
+}
+
+// findRepairs lists the fixes that would make the store consistent.
+func (l *listStore) findRepairs(report *ReconcileReport) ([]repair, error) {
+	due, err := l.getReferencesByIssue(StoreDueKey + "_")
+	if err != nil {
+		return nil, err
+	}
+	users, err := l.getReferencesByIssue(StoreUserListKey + "_")
+	if err != nil {
+		return nil, err
+	}
+	failed, err := l.getReferencesByIssue(failedListKey())
+	if err != nil {
+		return nil, err
+	}
+
+	itemKeys, err := l.listKeys(StoreIssueKey + "_")
+	if err != nil {
+		return nil, err
+	}
+
+	repairs := []repair{}
+	items := map[string]bool{}
+	for _, key := range itemKeys {
+		issueID := strings.TrimPrefix(key, StoreIssueKey+"_")
+		report.Items++
+
+		issue, err := l.GetReminder(issueID)
+		if err != nil {
+			if isDecodeError(err) {
+				repairs = append(repairs, repair{Kind: repairDeleteItem, Key: key, IssueID: issueID})
+			}
+			continue
+		}
+		items[issueID] = true
+
+		expected := map[string]int64{}
+		if issue.State == ReminderStateFailed {
+			expected[failedListKey()] = issue.When
+		} else {
+			expected[dueKey(issue.CreateBy, issue.When)] = issue.When
+			expected[userListKey(issue.CreateBy)] = issue.When
+		}
+
+		for _, refs := range []map[string]map[string]int64{due, users, failed} {
+			for key := range refs[issueID] {
+				if _, ok := expected[key]; !ok {
+					repairs = append(repairs, repair{Kind: repairRemove, Key: key, IssueID: issueID})
+				}
+			}
+		}
+		for key, date := range expected {
+			if !hasReference(due, users, failed, issueID, key) {
+				repairs = append(repairs, repair{Kind: repairAdd, Key: key, IssueID: issueID, Date: date})
+			}
+		}
+	}
+
+	// References to items that are gone are purged.
+	for _, refs := range []map[string]map[string]int64{due, users, failed} {
+		for issueID, keys := range refs {
+			if items[issueID] {
+				continue
+			}
+			for key := range keys {
+				repairs = append(repairs, repair{Kind: repairRemove, Key: key, IssueID: issueID})
+			}
+		}
+	}
+
+	return repairs, nil
+}
+
+func isDecodeError(err error) bool {
+	switch err.(type) {
+	case *json.SyntaxError, *json.UnmarshalTypeError:
+		return true
+	default:
+		return false
+	}
+}
+
+func hasReference(due, users, failed map[string]map[string]int64, issueID, key string) bool {
+	for _, refs := range []map[string]map[string]int64{due, users, failed} {
+		if _, ok := refs[issueID][key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getReferencesByIssue reads the lists kept at the keys starting with prefix, and
+// returns for every issue the keys of the lists that reference it, with the date.
+func (l *listStore) getReferencesByIssue(prefix string) (map[string]map[string]int64, error) {
+	keys, err := l.listKeys(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := map[string]map[string]int64{}
+	for _, key := range keys {
+		list, _, err := l.getList(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read the list %s", key)
+		}
+
+		for _, ref := range list {
+			if refs[ref.ReminderID] == nil {
+				refs[ref.ReminderID] = map[string]int64{}
+			}
+			refs[ref.ReminderID][key] = ref.ReminderDate
+		}
+	}
+
+	return refs, nil
+}
+
+func (l *listStore) applyRepair(r repair) error {
+	switch r.Kind {
+	case repairAdd:
+		return l.addReference(r.Key, r.Date, r.IssueID)
+	case repairRemove:
+		return l.removeReference(r.Key, r.IssueID)
+	case repairDeleteItem:
+		if appErr := l.api.KVDelete(r.Key); appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown repair %q", r.Kind)
+	}
+}
+
+func (l *listStore) getPendingRepairs() (map[string]bool, error) {
+	value, appErr := l.api.KVGet(StoreReconcileKey)
+	if appErr != nil {
+		return nil, errors.New(appErr.Error())
+	}
+
+	pending := map[string]bool{}
+	if value == nil {
+		return pending, nil
+	}
+
+	var repairs []repair
+	if err := json.Unmarshal(value, &repairs); err != nil {
+		// Start over, the repairs are found again on the next run.
+		return pending, nil
+	}
+	for _, r := range repairs {
+		pending[r.id()] = true
+	}
+
+	return pending, nil
+}
+
+func (l *listStore) setPendingRepairs(repairs []repair) error {
+	if len(repairs) == 0 {
+		if appErr := l.api.KVDelete(StoreReconcileKey); appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		return nil
+	}
+
+	value, err := json.Marshal(repairs)
+	if err != nil {
+		return err
+	}
+	if appErr := l.api.KVSet(StoreReconcileKey, value); appErr != nil {
+		return errors.New(appErr.Error())
+	}
+	return nil
+}
+
+// reconcileStore runs the reconciler on a single node of the cluster and reloads
+// the queue when references were fixed.
+func (p *Plugin) reconcileStore() {
+	mutex := newClusterMutex(p.API, lockKey("reconcile"))
+	locked, err := mutex.TryLock(reconcileLease)
+	if err != nil {
+		p.API.LogError("Unable to lock the reconciler. err=" + err.Error())
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() { _ = mutex.Unlock() }()
+
+	report, err := p.listManager.Reconcile()
+	if err != nil {
+		p.API.LogError("Unable to reconcile the KV store. err=" + err.Error())
+		return
+	}
+
+	p.checkDeactivatedUsers()
+
+	if report.Added+report.Removed+report.Deleted+report.Failed == 0 {
+		p.API.LogDebug("Reconciled the KV store, " + report.String())
+		return
+	}
+
+	p.API.LogInfo("Reconciled the KV store, " + report.String())
+	if err := p.listManager.LoadQueue(); err != nil {
+		p.API.LogError("Unable to reload the pending reminders. err=" + err.Error())
+	}
+}
+
+// periodicJob calls run every interval in its own goroutine, between Start and Stop.
+type periodicJob struct {
+	interval time.Duration
+	run      func()
+
+	mu   sync.Mutex
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newPeriodicJob(interval time.Duration, run func()) *periodicJob {
+	return &periodicJob{
+		interval: interval,
+		run:      run,
+	}
+}
+
+// Start launches the job goroutine. It is a no-op if the job is already running.
+func (j *periodicJob) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.stop != nil {
+		return
+	}
+	j.stop = make(chan struct{})
+
+	j.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				j.run()
+			}
+		}
+	}(j.stop)
+}
+
+// Stop signals the job goroutine to exit and waits for the run in flight, if any.
+func (j *periodicJob) Stop() {
+	j.mu.Lock()
+	if j.stop != nil {
+		close(j.stop)
+		j.stop = nil
+	}
+	j.mu.Unlock()
+
+	j.wg.Wait()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	userID := model.NewId()
+	orphanItem := &Reminder{ID: model.NewId(), CreateBy: userID, When: 1791972000000}
+	failedItem := &Reminder{ID: model.NewId(), CreateBy: userID, When: 1791972000000, State: ReminderStateFailed}
+	goneID := model.NewId()
+	corruptID := model.NewId()
+
+	kv := map[string][]byte{}
+	for _, reminder := range []*Reminder{orphanItem, failedItem} {
+		value, err := json.Marshal(reminder)
+		require.NoError(t, err)
+		kv[issueKey(reminder.ID)] = value
+	}
+	kv[issueKey(corruptID)] = []byte("{")
+
+	store := &listStore{api: newMemoryKVAPI(kv)}
+	// The failed item is still in the due index, and a reference outlived its item.
+	require.NoError(t, store.AddDueReference(userID, failedItem.When, failedItem.ID))
+	require.NoError(t, store.AddDueReference(userID, orphanItem.When+1, goneID))
+	require.NoError(t, store.AddUserReference(userID, orphanItem.When+1, goneID))
+
+	// Inconsistencies are only reported the first time, they may be in flight.
+	report, err := store.Reconcile()
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{Items: 3, Deferred: 7}, report)
+	assert.Contains(t, kv, issueKey(corruptID))
+
+	report, err = store.Reconcile()
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{Items: 3, Added: 3, Removed: 3, Deleted: 1}, report)
+	assert.NotContains(t, kv, issueKey(corruptID))
+	assert.NotContains(t, kv, StoreReconcileKey)
+
+	due, err := store.GetDueList()
+	require.NoError(t, err)
+	assert.Equal(t, []*ReminderRef{{ReminderID: orphanItem.ID, ReminderDate: orphanItem.When}}, due)
+
+	userRefs, err := store.GetUserList(userID)
+	require.NoError(t, err)
+	assert.Equal(t, []*ReminderRef{{ReminderID: orphanItem.ID, ReminderDate: orphanItem.When}}, userRefs)
+
+	failedRefs, err := store.GetFailedList()
+	require.NoError(t, err)
+	require.Len(t, failedRefs, 1)
+	assert.Equal(t, failedItem.ID, failedRefs[0].ReminderID)
+
+	report, err = store.Reconcile()
+	require.NoError(t, err)
+	assert.Equal(t, &ReconcileReport{Items: 2}, report)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RecurrenceDaily repeats the reminder every day at the same time.
+	RecurrenceDaily = "daily"
+	// RecurrenceWeekly repeats the reminder every week on the same day and time.
+	RecurrenceWeekly = "weekly"
+	// RecurrenceWeekdays repeats the reminder from Monday to Friday at the same time.
+	RecurrenceWeekdays = "weekdays"
+	// RecurrenceMonthly repeats the reminder every month on the same day and time.
+	RecurrenceMonthly = "monthly"
+
+	// maxCronLookahead bounds the search for the next time matching a cron expression.
+	maxCronLookahead = 5 * 366 * 24 * time.Hour
+)
+
+// Recurrence describes how a reminder repeats once it has been delivered. Rule is
+// either one of the presets or a five field cron expression
+// ("minute hour day-of-month month day-of-week"), evaluated in TimeZone.
+type Recurrence struct {
+	Rule     string `json:"rule"`
+	TimeZone string `json:"time_zone,omitempty"`
+	Start    int64  `json:"start,omitempty"`
+	Until    int64  `json:"until,omitempty"`
+	MaxCount int    `json:"max_count,omitempty"`
+	Count    int    `json:"count,omitempty"`
+}
+
+// IsValid checks that the rule and the time zone can be evaluated, and that a cron
+// expression matches at least one time.
+func (r *Recurrence) IsValid() error {
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		return errors.Wrap(err, "invalid time zone")
+	}
+	if r.MaxCount < 0 {
+		return errors.New("max count must not be negative")
+	}
+
+	switch r.Rule {
+	case RecurrenceDaily, RecurrenceWeekly, RecurrenceWeekdays, RecurrenceMonthly:
+		return nil
+	}
+
+	schedule, err := parseCron(r.Rule)
+	if err != nil {
+		return err
+	}
+	if _, ok := schedule.next(time.Now().In(loc)); !ok {
+		return errors.Errorf("the cron expression %q never matches", r.Rule)
+	}
+	return nil
+}
+
+// Next returns the first occurrence after both the previous occurrence and now, in
+// milliseconds. Count is the number of occurrences already delivered. It reports
+// false once the recurrence is over.
+func (r *Recurrence) Next(previous, now int64) (int64, bool) {
+	if r.MaxCount > 0 && r.Count >= r.MaxCount {
+		return 0, false
+	}
+
+	loc, err := time.LoadLocation(r.TimeZone)
+	if err != nil {
+		return 0, false
+	}
+
+	after := msToTime(previous).In(loc)
+	if now > previous {
+		after = msToTime(now).In(loc)
+	}
+
+	var next time.Time
+	switch r.Rule {
+	case RecurrenceDaily:
+		next = nextByDays(msToTime(previous).In(loc), after, 1)
+	case RecurrenceWeekly:
+		next = nextByDays(msToTime(previous).In(loc), after, 7)
+	case RecurrenceWeekdays:
+		next = msToTime(previous).In(loc)
+		for !next.After(after) || next.Weekday() == time.Saturday || next.Weekday() == time.Sunday {
+			next = next.AddDate(0, 0, 1)
+		}
+	case RecurrenceMonthly:
+		next = r.nextMonth(loc, after)
+	default:
+		schedule, err := parseCron(r.Rule)
+		if err != nil {
+			return 0, false
+		}
+		var ok bool
+		if next, ok = schedule.next(after); !ok {
+			return 0, false
+		}
+	}
+
+	nextMillis := next.UnixNano() / int64(time.Millisecond)
+	if r.Until > 0 && nextMillis > r.Until {
+		return 0, false
+	}
+
+	return nextMillis, true
+}
+
+// nextByDays steps from the previous occurrence by whole days, keeping the wall clock time.
+func nextByDays(from, after time.Time, days int) time.Time {
+	next := from
+	for !next.After(after) {
+		next = next.AddDate(0, 0, days)
+	}
+	return next
+}
+
+// nextMonth keeps the day of month of the first occurrence, using the last day of
+// shorter months instead of overflowing into the next one.
+func (r *Recurrence) nextMonth(loc *time.Location, after time.Time) time.Time {
+	start := msToTime(r.Start).In(loc)
+	for months := 1; ; months++ {
+		firstOfMonth := time.Date(start.Year(), start.Month()+time.Month(months), 1, start.Hour(), start.Minute(), start.Second(), 0, loc)
+		day := start.Day()
+		if last := firstOfMonth.AddDate(0, 1, -1).Day(); day > last {
+			day = last
+		}
+
+		next := firstOfMonth.AddDate(0, 0, day-1)
+		if next.After(after) {
+			return next
+		}
+	}
+}
+
+func msToTime(millis int64) time.Time {
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
+
+// cronSchedule holds the values allowed by each field of a cron expression.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	anyDay, anyWeekday                     bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("unknown recurrence %q, expected a preset or a cron expression with %d fields", expr, len(cronFields))
+	}
+
+	values := make([]map[int]bool, len(fields))
+	for i, field := range fields {
+		v, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	// Both 0 and 7 mean Sunday.
+	if values[4][7] {
+		values[4][0] = true
+	}
+
+	return &cronSchedule{
+		minutes:    values[0],
+		hours:      values[1],
+		days:       values[2],
+		months:     values[3],
+		weekdays:   values[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, spec cronField) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s < 1 {
+				return nil, errors.Errorf("invalid step in %s field %q", spec.name, field)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		low, high := spec.min, spec.max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.Errorf("invalid value in %s field %q", spec.name, field)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.Errorf("invalid range in %s field %q", spec.name, field)
+				}
+			} else if step > 1 {
+				high = spec.max
+			}
+		}
+
+		if low < spec.min || high > spec.max || low > high {
+			return nil, errors.Errorf("%s field %q is out of range %d-%d", spec.name, field, spec.min, spec.max)
+		}
+
+		for v := low; v <= high; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dayMatch := c.days[t.Day()]
+	weekdayMatch := c.weekdays[int(t.Weekday())]
+
+	// As in cron, a restricted day of month and day of week match if either does.
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekdayMatch
+	case c.anyWeekday:
+		return dayMatch
+	default:
+		return dayMatch || weekdayMatch
+	}
+}
+
+// next returns the first time strictly after the given one that matches the schedule.
+func (c *cronSchedule) next(after time.Time) (time.Time, bool) {
+	loc := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(maxCronLookahead)
+
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
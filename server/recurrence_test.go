@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecurrenceNext(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	ms := func(year int, month time.Month, day, hour, min int) int64 {
+		return time.Date(year, month, day, hour, min, 0, 0, loc).UnixNano() / int64(time.Millisecond)
+	}
+
+	// Friday, 2026-10-16 09:00 in Berlin.
+	friday := ms(2026, time.October, 16, 9, 0)
+
+	for name, tc := range map[string]struct {
+		recurrence Recurrence
+		previous   int64
+		now        int64
+		expected   int64
+		ok         bool
+	}{
+		"daily": {
+			recurrence: Recurrence{Rule: RecurrenceDaily},
+			previous:   friday,
+			now:        friday,
+			expected:   ms(2026, time.October, 17, 9, 0),
+			ok:         true,
+		},
+		"daily keeps the wall clock across DST": {
+			recurrence: Recurrence{Rule: RecurrenceDaily},
+			previous:   ms(2026, time.October, 24, 9, 0),
+			now:        ms(2026, time.October, 24, 9, 0),
+			expected:   ms(2026, time.October, 25, 9, 0),
+			ok:         true,
+		},
+		"daily skips missed occurrences": {
+			recurrence: Recurrence{Rule: RecurrenceDaily},
+			previous:   friday,
+			now:        ms(2026, time.October, 19, 12, 0),
+			expected:   ms(2026, time.October, 20, 9, 0),
+			ok:         true,
+		},
+		"weekly": {
+			recurrence: Recurrence{Rule: RecurrenceWeekly},
+			previous:   friday,
+			now:        friday,
+			expected:   ms(2026, time.October, 23, 9, 0),
+			ok:         true,
+		},
+		"weekdays skip the weekend": {
+			recurrence: Recurrence{Rule: RecurrenceWeekdays},
+			previous:   friday,
+			now:        friday,
+			expected:   ms(2026, time.October, 19, 9, 0),
+			ok:         true,
+		},
+		"monthly clamps to the end of the month": {
+			recurrence: Recurrence{Rule: RecurrenceMonthly, Start: ms(2027, time.January, 31, 9, 0)},
+			previous:   ms(2027, time.January, 31, 9, 0),
+			now:        ms(2027, time.January, 31, 9, 0),
+			expected:   ms(2027, time.February, 28, 9, 0),
+			ok:         true,
+		},
+		"monthly goes back to the start day": {
+			recurrence: Recurrence{Rule: RecurrenceMonthly, Start: ms(2027, time.January, 31, 9, 0)},
+			previous:   ms(2027, time.February, 28, 9, 0),
+			now:        ms(2027, time.February, 28, 9, 0),
+			expected:   ms(2027, time.March, 31, 9, 0),
+			ok:         true,
+		},
+		"cron every Monday at 9": {
+			recurrence: Recurrence{Rule: "0 9 * * 1"},
+			previous:   friday,
+			now:        friday,
+			expected:   ms(2026, time.October, 19, 9, 0),
+			ok:         true,
+		},
+		"cron with steps and ranges": {
+			recurrence: Recurrence{Rule: "*/30 8-17 * * 1-5"},
+			previous:   friday,
+			now:        ms(2026, time.October, 16, 17, 45),
+			expected:   ms(2026, time.October, 19, 8, 0),
+			ok:         true,
+		},
+		"until ends the recurrence": {
+			recurrence: Recurrence{Rule: RecurrenceDaily, Until: ms(2026, time.October, 17, 8, 0)},
+			previous:   friday,
+			now:        friday,
+		},
+		"max count ends the recurrence": {
+			recurrence: Recurrence{Rule: RecurrenceDaily, MaxCount: 2, Count: 2},
+			previous:   friday,
+			now:        friday,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tc.recurrence.TimeZone = "Europe/Berlin"
+			next, ok := tc.recurrence.Next(tc.previous, tc.now)
+			assert.Equal(t, tc.ok, ok)
+			assert.Equal(t, tc.expected, next)
+		})
+	}
+}
+
+func TestRecurrenceIsValid(t *testing.T) {
+	assert.NoError(t, (&Recurrence{Rule: RecurrenceWeekdays, TimeZone: "UTC"}).IsValid())
+	assert.NoError(t, (&Recurrence{Rule: "0 9 1,15 * *", TimeZone: "UTC"}).IsValid())
+	assert.Error(t, (&Recurrence{Rule: "every tuesday", TimeZone: "UTC"}).IsValid())
+	assert.Error(t, (&Recurrence{Rule: "60 9 * * *", TimeZone: "UTC"}).IsValid())
+	assert.NoError(t, (&Recurrence{Rule: "0 0 29 2 *", TimeZone: "UTC"}).IsValid())
+	assert.Error(t, (&Recurrence{Rule: "0 0 30 2 *", TimeZone: "UTC"}).IsValid())
+	assert.Error(t, (&Recurrence{Rule: "0 0 31 4,6,9,11 *", TimeZone: "UTC"}).IsValid())
+	assert.Error(t, (&Recurrence{Rule: RecurrenceDaily, TimeZone: "Mars/Olympus"}).IsValid())
+}
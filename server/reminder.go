@@ -1,20 +1,56 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReminderStateFailed marks a reminder that could not be delivered.
+	ReminderStateFailed = "failed"
+
+	// deliveryMaxAttempts is the number of delivery attempts before a reminder is marked as failed.
+	deliveryMaxAttempts = 6
+	// deliveryRetryBase is the delay before the first retry, doubled on every further attempt.
+	deliveryRetryBase = 30 * time.Second
+	// deliveryRetryMax caps the delay between two delivery attempts.
+	deliveryRetryMax = time.Hour
 )
 
 type Reminder struct {
-	ID       string `json:"id"`
-	Message  string `json:"message"`
-	CreateBy string `json:"create_by"`
-	CreateAt int64  `json:"create_at"`
-	PostID   string `json:"post_id"`
-	When     int64  `json:"reminder_at"`
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	CreateBy  string `json:"create_by"`
+	CreateAt  int64  `json:"create_at"`
+	PostID    string `json:"post_id"`
+	When      int64  `json:"reminder_at"`
+	State     string `json:"state,omitempty"`
+	Attempts  int    `json:"attempts,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+
+	Recurrence   *Recurrence     `json:"recurrence,omitempty"`
+	Target       *ReminderTarget `json:"target,omitempty"`
+	DeliveryMode string          `json:"delivery_mode,omitempty"`
+	// Delivered lists the users and channels of the target already reminded by the
+	// current delivery, so that retrying it does not remind them twice.
+	Delivered []string `json:"delivered,omitempty"`
+	// PostMessage is the message of the post when the reminder was set, to show how
+	// the post was edited since.
+	PostMessage string `json:"post_message,omitempty"`
+	// WorkingTime delays the delivery to each user until their working hours.
+	WorkingTime bool `json:"working_time,omitempty"`
+	// ScheduledAt is when a reminder delayed until working time, retried or snoozed
+	// was due, the occurrence its recurrence continues from.
+	ScheduledAt int64 `json:"scheduled_at,omitempty"`
 }
 
+// newReminder makes a reminder of the user about the post, due at when. Its
+// recurrence, target and delivery mode, if any, are set before it is added.
 func newReminder(userID, message, postID string, when int64) *Reminder {
 	return &Reminder{
 		ID:       model.NewId(),
@@ -22,73 +58,331 @@ func newReminder(userID, message, postID string, when int64) *Reminder {
 		CreateAt: model.GetMillis(),
 		Message:  message,
 		PostID:   postID,
-		When:     model.GetMillis() + when,
+		When:     when,
+	}
+}
+
+// errReminderBusy is returned when a reminder is not changed because it is being delivered.
+var errReminderBusy = errors.New("the reminder is being delivered")
+
+// claimReminder takes the delivery claim of the reminder, so that users do not change
+// it while a node delivers it. It returns errReminderBusy when the claim is held.
+func (p *Plugin) claimReminder(issueID string) (*clusterMutex, error) {
+	claim := newClusterMutex(p.API, claimKey(issueID))
+	claimed, err := claim.TryLock(deliveryLease)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, errReminderBusy
+	}
+	return claim, nil
+}
+
+// releaseReminder releases a claim taken by claimReminder.
+func (p *Plugin) releaseReminder(claim *clusterMutex) {
+	if uErr := claim.Unlock(); uErr != nil {
+		p.API.LogError("Unable to release the reminder claim. uErr=" + uErr.Error())
 	}
 }
 
-func (p *Plugin) TriggerReminders() {
+// TriggerReminders delivers the due reminders. It stops early, between two
+// deliveries, when ctx is canceled.
+func (p *Plugin) TriggerReminders(ctx context.Context) {
 	reminders, err := p.listManager.GetActiveIssues()
 	if err != nil {
 		return
 	}
 
-	for _, reminder := range reminders {
-		post, pErr := p.API.GetPost(reminder.PostID)
-		if pErr != nil {
-			p.API.LogDebug("Unable to fetch the post. pErr=" + pErr.Error())
-			_, _ = p.listManager.RemoveIssue(reminder.ID)
-			continue
+	for i, due := range reminders {
+		if ctx.Err() != nil {
+			// Put the remaining reminders back; the next activation reloads them anyway.
+			for _, rest := range reminders[i:] {
+				p.queue.Push(&ReminderRef{ReminderID: rest.ID, ReminderDate: rest.When})
+			}
+			return
 		}
 
-		channel, cErr := p.API.GetChannel(post.ChannelId)
-		if cErr != nil {
-			p.API.LogDebug("Unable to fetch the channel. cErr=" + cErr.Error())
-			_, _ = p.listManager.RemoveIssue(reminder.ID)
+		// Every node of a cluster runs the scheduler, so the delivery is claimed first.
+		claim := newClusterMutex(p.API, claimKey(due.ID))
+		claimed, cErr := claim.TryLock(deliveryLease)
+		if cErr != nil || !claimed {
+			if cErr != nil {
+				p.API.LogError("Unable to claim the reminder. cErr=" + cErr.Error())
+			}
+			// Another node may be delivering it; look again once its lease is over.
+			p.queue.Push(&ReminderRef{ReminderID: due.ID, ReminderDate: model.GetMillis() + deliveryLease.Milliseconds()})
 			continue
 		}
 
-		reminderMessage := ""
-		if reminder.Message != "" {
-			reminderMessage = fmt.Sprintf("\n\nYour reminder message is:\n%s", reminder.Message)
+		// The reminder may have been delivered, rescheduled or snoozed by another node
+		// since it was fetched, in which case this queue holds a stale date.
+		reminder, rErr := p.listManager.GetIssue(due.ID)
+		switch {
+		case rErr != nil || reminder.State == ReminderStateFailed:
+			// Delivered by another node, or failed for good.
+		case reminder.When > model.GetMillis():
+			p.queue.Push(&ReminderRef{ReminderID: reminder.ID, ReminderDate: reminder.When})
+		default:
+			if dErr := p.deliverReminder(reminder); dErr != nil {
+				p.retryReminder(reminder, dErr)
+			}
 		}
 
-		if !channel.IsGroupOrDirect() {
-			team, tErr := p.API.GetTeam(channel.TeamId)
-			if tErr != nil {
-				p.API.LogDebug("Unable to fetch the team. tErr=" + tErr.Error())
-				continue
-			}
+		if uErr := claim.Unlock(); uErr != nil {
+			p.API.LogError("Unable to release the reminder claim. uErr=" + uErr.Error())
+		}
+	}
+}
 
-			postLink := fmt.Sprintf("%s/%s/pl/%s", *p.API.GetConfig().ServiceSettings.SiteURL, team.Name, post.Id)
-			p.PostBotDM(reminder.CreateBy, fmt.Sprintf("You requested to be reminded about this [this post](%s) in ~%s: %s%s", postLink, channel.Name, postLink, reminderMessage))
+// deliverReminder sends the reminder to its owner and removes it once delivered.
+// Reminders that can never be delivered, like the ones whose post is gone, are
+// dropped. Any other failure is returned so the delivery can be retried.
+func (p *Plugin) deliverReminder(reminder *Reminder) error {
+	if !p.isActiveUser(reminder.CreateBy) {
+		policy := p.getConfiguration().accessLostPolicy()
+		if policy == AccessLostPolicyNotify {
+			policy = AccessLostPolicyPause
+		}
+		p.applyAccessLostPolicy(reminder, policy, "you were deactivated")
+		return nil
+	}
 
-			_, _ = p.listManager.RemoveIssue(reminder.ID)
-			continue
+	post, pErr := p.API.GetPost(reminder.PostID)
+	if pErr != nil {
+		if pErr.StatusCode != http.StatusNotFound {
+			return errors.Wrap(pErr, "unable to fetch the post")
 		}
+		p.API.LogDebug("Unable to fetch the post. pErr=" + pErr.Error())
+		p.removeDeletedPostReminder(reminder)
+		return nil
+	}
+	if post.DeleteAt != 0 {
+		p.removeDeletedPostReminder(reminder)
+		return nil
+	}
+
+	channel, cErr := p.API.GetChannel(post.ChannelId)
+	if cErr != nil {
+		if cErr.StatusCode != http.StatusNotFound {
+			return errors.Wrap(cErr, "unable to fetch the channel")
+		}
+		p.API.LogDebug("Unable to fetch the channel. cErr=" + cErr.Error())
+		_, _ = p.listManager.RemoveIssue(reminder.ID)
+		return nil
+	}
+
+	// The owner may have left the channel, or lost access to it, since the
+	// reminder was created.
+	if !canReadChannel(p.API, reminder.CreateBy, channel) {
+		p.failUnreadableReminder(reminder)
+		return nil
+	}
+
+	deferredUntil, err := p.sendReminder(reminder, post, channel)
+	if err != nil {
+		return err
+	}
+	if deferredUntil != 0 {
+		p.deferReminder(reminder, deferredUntil)
+		return nil
+	}
 
-		// Select a random team for the user.
-		// Dirty workaround, because a team is needed for the link.
-		teams, tErr := p.API.GetTeamsForUser(post.UserId)
-		if tErr != nil {
-			p.API.LogDebug("Unable to fetch the team. tErr=" + tErr.Error())
-			_, _ = p.listManager.RemoveIssue(reminder.ID)
+	p.completeReminder(reminder)
+	return nil
+}
+
+// sendReminder reminds the owner of the reminder, or its target, about the post.
+// The users and channels reminded are recorded in Delivered, so that a failed
+// delivery can be retried without reminding them twice. Users who are not working,
+// for reminders delivered at working time, and users in Do Not Disturb who hold
+// their reminders are skipped; sendReminder then returns the earliest time to try
+// them again. Users out of office get the reminder in a summary once they are back.
+func (p *Plugin) sendReminder(reminder *Reminder, post *model.Post, postChannel *model.Channel) (int64, error) {
+	ownerName := p.listManager.GetUserName(reminder.CreateBy)
+	now := time.Now()
+	var deferredUntil int64
+	for _, userID := range reminder.Target.recipients(reminder.CreateBy) {
+		if containsString(reminder.Delivered, userID) {
 			continue
 		}
 
-		var randomTeam *model.Team
-		for _, team := range teams {
-			randomTeam = team
-			break
+		if reminder.WorkingTime {
+			if next := p.nextWorkingTime(userID, now); next.After(now) {
+				deferredUntil = earliestMillis(deferredUntil, next)
+				continue
+			}
 		}
 
-		if randomTeam == nil {
-			p.API.LogDebug("User is not member in any team.")
-			_, _ = p.listManager.RemoveIssue(reminder.ID)
+		mode := p.deliveryMode(reminder, userID)
+		switch p.getStatusDelivery(userID) {
+		case statusHold:
+			deferredUntil = earliestMillis(deferredUntil, now.Add(dndRecheckInterval))
+			continue
+		case statusAway:
+			if err := p.deferWhileAway(reminder, userID); err != nil {
+				return 0, err
+			}
+			reminder.Delivered = append(reminder.Delivered, userID)
 			continue
+		case statusDeliverSilently:
+			mode = DeliveryModeDM
 		}
 
-		postLink := fmt.Sprintf("%s/%s/pl/%s", *p.API.GetConfig().ServiceSettings.SiteURL, randomTeam.Name, post.Id)
-		p.PostBotDM(reminder.CreateBy, fmt.Sprintf("You requested to be reminded about [this post](%s) in a DM: %s%s", postLink, postLink, reminderMessage))
-		_, _ = p.listManager.RemoveIssue(reminder.ID)
+		// A recipient may have lost access to the post, or been deactivated, since the
+		// reminder was created.
+		if !canReadChannel(p.API, userID, postChannel) {
+			p.API.LogDebug("The recipient of the reminder cannot read its post.", "reminder_id", reminder.ID, "user_id", userID)
+		} else if userID != reminder.CreateBy && !p.isActiveUser(userID) {
+			p.API.LogDebug("The recipient of the reminder was deactivated.", "reminder_id", reminder.ID, "user_id", userID)
+		} else {
+			text := "You asked me to remind you about this post."
+			if userID != reminder.CreateBy {
+				text = fmt.Sprintf("@%s asked me to remind you about this post.", ownerName)
+			}
+			attachment := p.reminderAttachment(reminder, userID, post, postChannel, p.postPermalink(userID, post, postChannel))
+			if err := p.remindUser(reminder, userID, mode, post, postChannel, text, attachment); err != nil {
+				return 0, err
+			}
+		}
+		reminder.Delivered = append(reminder.Delivered, userID)
+	}
+
+	if target := reminder.Target; target != nil && target.ChannelID != "" && !containsString(reminder.Delivered, target.ChannelID) {
+		mention := ""
+		if target.GroupName != "" {
+			mention = "@" + target.GroupName + " "
+		}
+		text := fmt.Sprintf("%s@%s set a reminder about this post.", mention, ownerName)
+		attachment := p.reminderAttachment(reminder, reminder.CreateBy, post, postChannel, p.postPermalink(reminder.CreateBy, post, postChannel))
+		if err := p.PostBotChannelMessage(target.ChannelID, text, attachment); err != nil {
+			return 0, err
+		}
+		reminder.Delivered = append(reminder.Delivered, target.ChannelID)
+	}
+
+	return deferredUntil, nil
+}
+
+// earliestMillis returns the earliest of t and deadline, in milliseconds, where a zero
+// deadline is unset.
+func earliestMillis(deadline int64, t time.Time) int64 {
+	millis := t.UnixNano() / int64(time.Millisecond)
+	if deadline == 0 || millis < deadline {
+		return millis
+	}
+	return deadline
+}
+
+// deferReminder moves a reminder delivered at working time to when the next of its
+// users starts working. Those already reminded are kept in Delivered.
+func (p *Plugin) deferReminder(reminder *Reminder, until int64) {
+	if err := p.postponeReminder(reminder, until); err != nil {
+		p.API.LogError("Unable to defer the reminder until working time. err=" + err.Error())
+	}
+}
+
+// postponeReminder moves a pending reminder to a later time, and keeps the occurrence
+// it was due at in ScheduledAt, so that its recurrence does not drift.
+func (p *Plugin) postponeReminder(reminder *Reminder, when int64) error {
+	if reminder.ScheduledAt == 0 {
+		reminder.ScheduledAt = reminder.When
+	}
+	return p.listManager.RescheduleIssue(reminder, when)
+}
+
+// completeReminder removes a delivered reminder, or schedules its next occurrence
+// when it is recurring.
+func (p *Plugin) completeReminder(reminder *Reminder) {
+	p.recordHistory(reminder, HistoryEventFired, 0)
+
+	if reminder.Recurrence != nil {
+		// The recurrence continues from the occurrence, not from its delivery.
+		occurrence := reminder.When
+		if reminder.ScheduledAt != 0 {
+			occurrence = reminder.ScheduledAt
+		}
+
+		reminder.Recurrence.Count++
+		if next, ok := reminder.Recurrence.Next(occurrence, model.GetMillis()); ok {
+			reminder.Attempts = 0
+			reminder.LastError = ""
+			reminder.Delivered = nil
+			reminder.ScheduledAt = 0
+			if err := p.listManager.RescheduleIssue(reminder, next); err != nil {
+				p.API.LogError("Unable to schedule the next occurrence of the reminder. err=" + err.Error())
+			}
+			return
+		}
+	}
+
+	_, _ = p.listManager.RemoveIssue(reminder.ID)
+}
+
+// removeDeletedPostReminder removes a reminder whose post was deleted, and tells
+// its owner. The plugin API has no hook for deleted posts, so this happens when
+// the reminder is due.
+func (p *Plugin) removeDeletedPostReminder(reminder *Reminder) {
+	if _, err := p.listManager.RemoveIssue(reminder.ID); err != nil {
+		p.API.LogError("Unable to remove the reminder of a deleted post. err=" + err.Error())
+		return
+	}
+	p.recordHistory(reminder, HistoryEventPostDeleted, 0)
+
+	message := "The post of one of your reminders was deleted, so I removed the reminder."
+	if reminder.Message != "" {
+		message += "\nIts message was: " + reminder.Message
+	}
+	_ = p.PostBotDM(reminder.CreateBy, message)
+}
+
+// retryReminder schedules another delivery attempt with an exponential backoff,
+// or marks the reminder as failed once it ran out of attempts.
+func (p *Plugin) retryReminder(reminder *Reminder, deliveryErr error) {
+	reminder.Attempts++
+	reminder.LastError = deliveryErr.Error()
+
+	if reminder.Attempts < deliveryMaxAttempts {
+		p.API.LogWarn("Unable to deliver the reminder, retrying.", "reminder_id", reminder.ID, "attempt", reminder.Attempts, "err", deliveryErr.Error())
+		if err := p.postponeReminder(reminder, model.GetMillis()+deliveryBackoff(reminder.Attempts).Milliseconds()); err != nil {
+			p.API.LogError("Unable to reschedule the reminder. err=" + err.Error())
+		}
+		return
+	}
+
+	p.API.LogError("Unable to deliver the reminder, giving up.", "reminder_id", reminder.ID, "user_id", reminder.CreateBy, "attempts", reminder.Attempts, "err", deliveryErr.Error())
+	if err := p.listManager.FailIssue(reminder); err != nil {
+		p.API.LogError("Unable to mark the reminder as failed. err=" + err.Error())
+		return
+	}
+	p.recordHistory(reminder, HistoryEventFailed, 0)
+
+	_ = p.PostBotDM(reminder.CreateBy, fmt.Sprintf("I could not deliver one of your reminders after %d attempts. You can find it in your failed reminders.", reminder.Attempts))
+}
+
+// failUnreadableReminder pauses, or cancels by the access lost policy, a reminder
+// whose post the owner can no longer read, without disclosing anything about the post.
+func (p *Plugin) failUnreadableReminder(reminder *Reminder) {
+	p.API.LogDebug("The owner of the reminder cannot read its post anymore.", "reminder_id", reminder.ID, "user_id", reminder.CreateBy)
+
+	// Once the reminder is due, there is no time left to notify the owner first.
+	if p.getConfiguration().accessLostPolicy() == AccessLostPolicyCancel {
+		if p.applyAccessLostPolicy(reminder, AccessLostPolicyCancel, "you can no longer read the post") {
+			_ = p.PostBotDM(reminder.CreateBy, "I canceled one of your reminders because you can no longer read its post.")
+		}
+		return
+	}
+
+	if p.applyAccessLostPolicy(reminder, AccessLostPolicyPause, "you can no longer read the post") {
+		_ = p.PostBotDM(reminder.CreateBy, "I could not deliver one of your reminders because you can no longer read its post. You can find it in your failed reminders.")
+	}
+}
+
+// deliveryBackoff returns the delay before the given delivery attempt is retried.
+func deliveryBackoff(attempt int) time.Duration {
+	backoff := deliveryRetryBase << uint(attempt-1)
+	if backoff <= 0 || backoff > deliveryRetryMax {
+		return deliveryRetryMax
 	}
+	return backoff
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestPlugin returns a plugin, as run by one node of a cluster, using the given API.
+func newTestPlugin(api *plugintest.API) *Plugin {
+	p := &Plugin{queue: newReminderQueue()}
+	p.SetAPI(api)
+	p.listManager = NewListManager(api, p.queue)
+	return p
+}
+
+func TestTriggerRemindersClaim(t *testing.T) {
+	kv := map[string][]byte{}
+	api := newMemoryKVAPI(kv)
+	// Two nodes share the KV store, each with its own queue. No post is mocked, so
+	// a delivery fails the test.
+	first := newTestPlugin(api)
+	second := newTestPlugin(api)
+
+	due := model.GetMillis() - 1000
+	reminder := &Reminder{ID: model.NewId(), CreateBy: model.NewId(), PostID: model.NewId(), When: due}
+	value, err := json.Marshal(reminder)
+	require.NoError(t, err)
+	kv[issueKey(reminder.ID)] = value
+
+	t.Run("claimed by another node", func(t *testing.T) {
+		claim := newClusterMutex(first.API, claimKey(reminder.ID))
+		claimed, err := claim.TryLock(deliveryLease)
+		require.NoError(t, err)
+		require.True(t, claimed)
+
+		second.queue.Push(&ReminderRef{ReminderID: reminder.ID, ReminderDate: due})
+		second.TriggerReminders(context.Background())
+
+		next, ok := second.queue.Next()
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, next, model.GetMillis()+deliveryLease.Milliseconds()-1000)
+
+		require.NoError(t, claim.Unlock())
+		second.queue.Remove(reminder.ID)
+	})
+
+	t.Run("rescheduled by another node", func(t *testing.T) {
+		second.queue.Push(&ReminderRef{ReminderID: reminder.ID, ReminderDate: due})
+
+		// The first node delivers the recurring reminder and moves it to its next occurrence.
+		reminder.When = model.GetMillis() + 3600*1000
+		value, err := json.Marshal(reminder)
+		require.NoError(t, err)
+		kv[issueKey(reminder.ID)] = value
+
+		second.TriggerReminders(context.Background())
+
+		next, ok := second.queue.Next()
+		require.True(t, ok)
+		assert.Equal(t, reminder.When, next)
+		assert.NotContains(t, kv, claimKey(reminder.ID))
+	})
+}
+
+func TestDeliveryBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, deliveryBackoff(1))
+	assert.Equal(t, time.Minute, deliveryBackoff(2))
+	assert.Equal(t, 32*time.Minute, deliveryBackoff(7))
+	assert.Equal(t, deliveryRetryMax, deliveryBackoff(8))
+	assert.Equal(t, deliveryRetryMax, deliveryBackoff(100))
+}
+
+func TestRetryReminder(t *testing.T) {
+	userID := model.NewId()
+	otherUserID := model.NewId()
+	adminID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+	api := p.API.(*plugintest.API)
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	api.On("GetDirectChannel", userID, p.BotUserID).Return(&model.Channel{Id: model.NewId()}, nil)
+	api.On("CreatePost", mock.AnythingOfType("*model.Post")).Return(&model.Post{}, nil).Once()
+	api.On("HasPermissionTo", userID, model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	api.On("HasPermissionTo", otherUserID, model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	api.On("HasPermissionTo", adminID, model.PERMISSION_MANAGE_SYSTEM).Return(true)
+
+	reminder := newReminder(userID, "Read it", post.Id, model.GetMillis())
+	require.NoError(t, p.listManager.AddIssue(reminder))
+
+	for attempt := 1; attempt < deliveryMaxAttempts; attempt++ {
+		before := model.GetMillis()
+		p.retryReminder(reminder, errors.New("unable to post"))
+
+		stored, err := p.listManager.GetIssue(reminder.ID)
+		require.NoError(t, err)
+		assert.Equal(t, attempt, stored.Attempts)
+		assert.Equal(t, "unable to post", stored.LastError)
+		assert.Empty(t, stored.State)
+		assert.GreaterOrEqual(t, stored.When, before+deliveryBackoff(attempt).Milliseconds())
+
+		next, ok := p.queue.Next()
+		require.True(t, ok)
+		assert.Equal(t, stored.When, next)
+	}
+
+	// The last attempt gives up, and tells the owner.
+	p.retryReminder(reminder, errors.New("unable to post"))
+	api.AssertCalled(t, "CreatePost", mock.AnythingOfType("*model.Post"))
+
+	stored, err := p.listManager.GetIssue(reminder.ID)
+	require.NoError(t, err)
+	assert.Equal(t, ReminderStateFailed, stored.State)
+	assert.Equal(t, deliveryMaxAttempts, stored.Attempts)
+	_, ok := p.queue.Next()
+	assert.False(t, ok)
+	pending, err := p.listManager.GetUserIssues(userID)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	other := newReminder(otherUserID, "", post.Id, model.GetMillis())
+	require.NoError(t, p.listManager.(*listManager).store.AddReminder(other))
+	require.NoError(t, p.listManager.FailIssue(other))
+
+	failedIDs := func(userID string) []string {
+		w := serveAPI(p, http.MethodGet, "/failed", userID, "")
+		require.Equal(t, http.StatusOK, w.Code)
+		var failed []*Reminder
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+		ids := []string{}
+		for _, reminder := range failed {
+			assert.Empty(t, reminder.PostMessage)
+			ids = append(ids, reminder.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{reminder.ID}, failedIDs(userID))
+	assert.Equal(t, []string{other.ID}, failedIDs(otherUserID))
+	assert.ElementsMatch(t, []string{reminder.ID, other.ID}, failedIDs(adminID))
+}
+
+func TestPostponedRecurringReminder(t *testing.T) {
+	userID := model.NewId()
+	p, post := newAPITestPlugin(userID)
+	api := p.API.(*plugintest.API)
+	api.On("LogWarn", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+
+	now := time.Now().UTC()
+	occurrence := time.Date(now.Year(), now.Month(), now.Day()-1, 9, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	reminder := newReminder(userID, "Stand-up", post.Id, occurrence)
+	reminder.Recurrence = &Recurrence{Rule: RecurrenceDaily, TimeZone: "UTC"}
+	require.NoError(t, p.listManager.AddIssue(reminder))
+
+	// The delivery is retried, then the owner snoozes the reminder.
+	p.retryReminder(reminder, errors.New("unable to post"))
+	w := serveAPI(p, http.MethodPost, "/reminders/"+reminder.ID+"/snooze", userID, `{"remember_at": "60000"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	stored, err := p.listManager.GetIssue(reminder.ID)
+	require.NoError(t, err)
+	assert.Equal(t, occurrence, stored.ScheduledAt)
+	p.completeReminder(stored)
+
+	stored, err = p.listManager.GetIssue(reminder.ID)
+	require.NoError(t, err)
+	next := msToTime(stored.When).UTC()
+	assert.True(t, next.After(now))
+	assert.Equal(t, 9, next.Hour())
+	assert.Zero(t, next.Minute())
+	assert.Zero(t, next.Second())
+	assert.Zero(t, stored.ScheduledAt)
+}
@@ -1,29 +1,265 @@
 package main
 
 import (
+	"container/heap"
+	"context"
+	"sync"
 	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
 )
 
-func (p *Plugin) Run() {
-	p.Stop()
-	if !p.running {
-		p.running = true
-		p.runner()
+type queueItem struct {
+	ref   ReminderRef
+	index int
+}
+
+// reminderHeap is a min-heap of reminder references ordered by their reminder date.
+type reminderHeap []*queueItem
+
+func (h reminderHeap) Len() int           { return len(h) }
+func (h reminderHeap) Less(i, j int) bool { return h[i].ref.ReminderDate < h[j].ref.ReminderDate }
+
+func (h reminderHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *reminderHeap) Push(x interface{}) {
+	item := x.(*queueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *reminderHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// reminderQueue keeps the pending reminder references in memory, ordered by their
+// reminder date, and wakes the scheduler whenever the earliest deadline changes.
+type reminderQueue struct {
+	mu    sync.Mutex
+	items reminderHeap
+	byID  map[string]*queueItem
+	wake  chan struct{}
+}
+
+func newReminderQueue() *reminderQueue {
+	return &reminderQueue{
+		byID: map[string]*queueItem{},
+		wake: make(chan struct{}, 1),
 	}
 }
 
-func (p *Plugin) Stop() {
-	p.running = false
+// Reset replaces the content of the queue with the given references.
+func (q *reminderQueue) Reset(refs []*ReminderRef) {
+	q.mu.Lock()
+	q.items = make(reminderHeap, 0, len(refs))
+	q.byID = make(map[string]*queueItem, len(refs))
+	for _, ref := range refs {
+		item := &queueItem{ref: *ref, index: len(q.items)}
+		q.items = append(q.items, item)
+		q.byID[ref.ReminderID] = item
+	}
+	heap.Init(&q.items)
+	q.mu.Unlock()
+
+	q.signal()
 }
 
-func (p *Plugin) runner() {
-	go func() {
-		<-time.NewTimer(time.Second * 5).C
-		if !p.running {
+// Push adds a reference to the queue, or moves it if it is already queued.
+func (q *reminderQueue) Push(ref *ReminderRef) {
+	q.mu.Lock()
+	if item, ok := q.byID[ref.ReminderID]; ok {
+		item.ref = *ref
+		heap.Fix(&q.items, item.index)
+	} else {
+		item = &queueItem{ref: *ref}
+		heap.Push(&q.items, item)
+		q.byID[ref.ReminderID] = item
+	}
+	earliest := q.items[0].ref.ReminderID == ref.ReminderID
+	q.mu.Unlock()
+
+	if earliest {
+		q.signal()
+	}
+}
+
+// Remove drops a reference from the queue. It is a no-op if the reference is not queued.
+func (q *reminderQueue) Remove(reminderID string) {
+	q.mu.Lock()
+	item, ok := q.byID[reminderID]
+	if !ok {
+		q.mu.Unlock()
+		return
+	}
+	earliest := item.index == 0
+	heap.Remove(&q.items, item.index)
+	delete(q.byID, reminderID)
+	q.mu.Unlock()
+
+	if earliest {
+		q.signal()
+	}
+}
+
+// PopDue removes and returns every reference due at or before now, earliest first.
+func (q *reminderQueue) PopDue(now int64) []*ReminderRef {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	due := []*ReminderRef{}
+	for len(q.items) > 0 && q.items[0].ref.ReminderDate <= now {
+		item := heap.Pop(&q.items).(*queueItem)
+		delete(q.byID, item.ref.ReminderID)
+		ref := item.ref
+		due = append(due, &ref)
+	}
+
+	return due
+}
+
+// Next returns the reminder date of the earliest queued reference.
+func (q *reminderQueue) Next() (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return 0, false
+	}
+	return q.items[0].ref.ReminderDate, true
+}
+
+func (q *reminderQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduler delivers the queued reminders when they are due. It owns a single
+// goroutine, started by Start and drained by Stop.
+type scheduler struct {
+	queue   *reminderQueue
+	trigger func(ctx context.Context)
+	// reload adds to the queue the reminders of the store due from since, the last
+	// successful reload, to until, to pick up reminders added or released by other
+	// nodes.
+	reload func(since, until time.Time) error
+	// interval bounds how long the runner sleeps. It is also the interval at which the
+	// queue is reloaded from the store, looking one interval ahead.
+	interval func() time.Duration
+	logError func(msg string, keyValuePairs ...interface{})
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func newScheduler(queue *reminderQueue, trigger func(ctx context.Context), reload func(since, until time.Time) error, interval func() time.Duration, logError func(msg string, keyValuePairs ...interface{})) *scheduler {
+	return &scheduler{
+		queue:    queue,
+		trigger:  trigger,
+		reload:   reload,
+		interval: interval,
+		logError: logError,
+	}
+}
+
+// Start launches the scheduler goroutine. It is a no-op if the scheduler is already running.
+func (s *scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.stop = make(chan struct{})
+
+	s.wg.Add(1)
+	go func(stop chan struct{}) {
+		defer s.wg.Done()
+		s.run(ctx, stop)
+	}(s.stop)
+}
+
+// Stop signals the scheduler goroutine to exit and waits until the delivery in
+// flight, if any, is finished.
+func (s *scheduler) Stop() {
+	s.mu.Lock()
+	if s.cancel != nil {
+		close(s.stop)
+		s.cancel()
+		s.cancel = nil
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// run sleeps until the earliest queued reminder is due, or until the queue
+// signals that the earliest deadline changed.
+func (s *scheduler) run(ctx context.Context, stop chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	lastSync := time.Now()
+	syncedAt := lastSync
+
+	for {
+		select {
+		case <-stop:
 			return
+		case <-timer.C:
+		case <-s.queue.wake:
+		}
+
+		if now := time.Now(); now.Sub(lastSync) >= s.interval() {
+			if err := s.reload(syncedAt, now.Add(s.interval())); err != nil {
+				s.logError("Unable to reload pending reminders. err=" + err.Error())
+			} else {
+				syncedAt = now
+			}
+			lastSync = now
+		}
+
+		s.trigger(ctx)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
 		}
+		timer.Reset(s.nextWakeUp())
+	}
+}
 
-		p.TriggerReminders()
-		p.runner()
-	}()
+// nextWakeUp returns how long the scheduler may sleep before the next reminder is due.
+func (s *scheduler) nextWakeUp() time.Duration {
+	interval := s.interval()
+	next, ok := s.queue.Next()
+	if !ok {
+		return interval
+	}
+
+	wait := time.Duration(next-model.GetMillis()) * time.Millisecond
+	if wait < 0 {
+		return 0
+	}
+	if wait > interval {
+		return interval
+	}
+	return wait
 }
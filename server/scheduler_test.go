@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReminderQueue(t *testing.T) {
+	q := newReminderQueue()
+	q.Reset([]*ReminderRef{
+		{ReminderID: "c", ReminderDate: 300},
+		{ReminderID: "a", ReminderDate: 100},
+	})
+	q.Push(&ReminderRef{ReminderID: "b", ReminderDate: 200})
+
+	next, ok := q.Next()
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), next)
+
+	q.Push(&ReminderRef{ReminderID: "a", ReminderDate: 400})
+	q.Remove("b")
+	q.Remove("unknown")
+
+	due := q.PopDue(350)
+	assert.Len(t, due, 1)
+	assert.Equal(t, "c", due[0].ReminderID)
+
+	next, ok = q.Next()
+	assert.True(t, ok)
+	assert.Equal(t, int64(400), next)
+
+	assert.Empty(t, q.PopDue(399))
+	assert.Len(t, q.PopDue(400), 1)
+
+	_, ok = q.Next()
+	assert.False(t, ok)
+}
+
+func TestSchedulerLifecycle(t *testing.T) {
+	q := newReminderQueue()
+	triggered := make(chan struct{}, 10)
+	s := newScheduler(q, func(ctx context.Context) {
+		q.PopDue(model.GetMillis())
+		triggered <- struct{}{}
+	}, func(since, until time.Time) error {
+		return nil
+	}, func() time.Duration {
+		return time.Minute
+	}, func(msg string, keyValuePairs ...interface{}) {})
+
+	s.Start()
+	s.Start()
+	<-triggered
+
+	q.Push(&ReminderRef{ReminderID: "a", ReminderDate: 0})
+	<-triggered
+
+	s.Stop()
+	s.Stop()
+
+	q.signal()
+	select {
+	case <-triggered:
+		t.Fatal("scheduler triggered after Stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DoNotDisturbDeliver delivers reminders to users in Do Not Disturb as usual.
+	DoNotDisturbDeliver = "deliver"
+	// DoNotDisturbHold holds reminders until users are no longer in Do Not Disturb.
+	DoNotDisturbHold = "hold"
+	// DoNotDisturbSilent delivers reminders to users in Do Not Disturb by a direct
+	// message, which their status keeps from notifying them.
+	DoNotDisturbSilent = "silent"
+
+	// OutOfOfficeDefer sums up the reminders due while users are out of office once
+	// they are back.
+	OutOfOfficeDefer = "defer"
+	// OutOfOfficeDeliver delivers reminders to users out of office as usual.
+	OutOfOfficeDeliver = "deliver"
+
+	// StoreAwayKey is the key prefix used to store the reminders due while a user was
+	// out of office.
+	StoreAwayKey = "away"
+	// StoreAwayUsersKey is the key of the users with reminders due while they were out
+	// of office, so that they are found without listing the store.
+	StoreAwayUsersKey = "away_users"
+
+	// dndRecheckInterval is how often a held reminder checks whether its user left Do
+	// Not Disturb. The statuses of the plugin API do not tell when it ends.
+	dndRecheckInterval = 15 * time.Minute
+	// awayCheckInterval is how often the users out of office are checked for their return.
+	awayCheckInterval = 10 * time.Minute
+	// awayLease bounds how long a node may hold the lock of the away summaries.
+	awayLease = 5 * time.Minute
+)
+
+var (
+	doNotDisturbModes = []string{DoNotDisturbDeliver, DoNotDisturbHold, DoNotDisturbSilent}
+	outOfOfficeModes  = []string{OutOfOfficeDefer, OutOfOfficeDeliver}
+)
+
+// AwayEntry is a reminder that came due while its user was out of office.
+type AwayEntry struct {
+	ReminderID string `json:"reminder_id"`
+	PostID     string `json:"post_id"`
+	Message    string `json:"message"`
+	CreateBy   string `json:"create_by"`
+	RemindAt   int64  `json:"reminder_at"`
+}
+
+func awayKey(userID string) string {
+	return fmt.Sprintf("%s_%s", StoreAwayKey, userID)
+}
+
+// AddAwayEntry appends an entry to the reminders due while the user is out of office.
+func (l *listStore) AddAwayEntry(userID string, entry *AwayEntry) error {
+	// The user is added to the index first, so that the summary is never missed.
+	if err := l.addAwayUser(userID); err != nil {
+		return err
+	}
+
+	for i := 0; i < StoreRetries; i++ {
+		entries, originalJSONEntries, err := l.getAwayEntries(userID)
+		if err != nil {
+			return err
+		}
+
+		newJSONEntries, jsonErr := json.Marshal(append(entries, entry))
+		if jsonErr != nil {
+			return jsonErr
+		}
+
+		ok, appErr := l.api.KVCompareAndSet(awayKey(userID), originalJSONEntries, newJSONEntries)
+		if appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("unable to store away entry")
+}
+
+// GetAwayEntries returns the reminders due while the user was out of office, oldest first.
+func (l *listStore) GetAwayEntries(userID string) ([]*AwayEntry, error) {
+	entries, _, err := l.getAwayEntries(userID)
+	return entries, err
+}
+
+// RemoveAwayEntries drops the count oldest reminders due while the user was out of
+// office. The user leaves the index once no entry is left.
+func (l *listStore) RemoveAwayEntries(userID string, count int) error {
+	for i := 0; i < StoreRetries; i++ {
+		entries, originalJSONEntries, err := l.getAwayEntries(userID)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return l.removeAwayUser(userID)
+		}
+		if count > len(entries) {
+			count = len(entries)
+		}
+
+		var ok bool
+		var appErr *model.AppError
+		if count == len(entries) {
+			ok, appErr = l.api.KVCompareAndDelete(awayKey(userID), originalJSONEntries)
+		} else {
+			newJSONEntries, jsonErr := json.Marshal(entries[count:])
+			if jsonErr != nil {
+				return jsonErr
+			}
+			ok, appErr = l.api.KVCompareAndSet(awayKey(userID), originalJSONEntries, newJSONEntries)
+		}
+		if appErr != nil {
+			return errors.New(appErr.Error())
+		}
+		if ok {
+			if count == len(entries) {
+				return l.removeAwayUser(userID)
+			}
+			return nil
+		}
+	}
+
+	return errors.New("unable to remove away entries")
+}
+
+// GetAwayUserIDs returns the users with reminders due while they were out of office.
+func (l *listStore) GetAwayUserIDs() ([]string, error) {
+	userIDs, _, err := l.getAwayUsers()
+	return userIDs, err
+}
+
+// addAwayUser adds the user to the index of the users out of office, unless already there.
+func (l *listStore) addAwayUser(userID string) error {
+	for i := 0; i < StoreRetries; i++ {
+		userIDs, originalJSONUsers, err := l.getAwayUsers()
+		if err != nil {
+			return err
+		}
+		if containsString(userIDs, userID) {
+			return nil
+		}
+
+		ok, err := l.saveAwayUsers(append(userIDs, userID), originalJSONUsers)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("unable to store the away users")
+}
+
+// removeAwayUser removes the user from the index of the users out of office. The user
+// is added back when an entry was added in the meantime.
+func (l *listStore) removeAwayUser(userID string) error {
+	for i := 0; i < StoreRetries; i++ {
+		userIDs, originalJSONUsers, err := l.getAwayUsers()
+		if err != nil {
+			return err
+		}
+		if !containsString(userIDs, userID) {
+			return nil
+		}
+
+		kept := []string{}
+		for _, listed := range userIDs {
+			if listed != userID {
+				kept = append(kept, listed)
+			}
+		}
+		ok, err := l.saveAwayUsers(kept, originalJSONUsers)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		entries, _, err := l.getAwayEntries(userID)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return l.addAwayUser(userID)
+		}
+		return nil
+	}
+
+	return errors.New("unable to store the away users")
+}
+
+func (l *listStore) getAwayUsers() ([]string, []byte, error) {
+	originalJSONUsers, appErr := l.api.KVGet(StoreAwayUsersKey)
+	if appErr != nil {
+		return nil, nil, errors.New(appErr.Error())
+	}
+	if originalJSONUsers == nil {
+		return []string{}, nil, nil
+	}
+
+	var userIDs []string
+	if err := json.Unmarshal(originalJSONUsers, &userIDs); err != nil {
+		return nil, nil, err
+	}
+
+	return userIDs, originalJSONUsers, nil
+}
+
+func (l *listStore) saveAwayUsers(userIDs []string, originalJSONUsers []byte) (bool, error) {
+	newJSONUsers, jsonErr := json.Marshal(userIDs)
+	if jsonErr != nil {
+		return false, jsonErr
+	}
+
+	ok, appErr := l.api.KVCompareAndSet(StoreAwayUsersKey, originalJSONUsers, newJSONUsers)
+	if appErr != nil {
+		return false, errors.New(appErr.Error())
+	}
+
+	return ok, nil
+}
+
+func (l *listStore) getAwayEntries(userID string) ([]*AwayEntry, []byte, error) {
+	originalJSONEntries, appErr := l.api.KVGet(awayKey(userID))
+	if appErr != nil {
+		return nil, nil, errors.New(appErr.Error())
+	}
+	if originalJSONEntries == nil {
+		return []*AwayEntry{}, nil, nil
+	}
+
+	var entries []*AwayEntry
+	if err := json.Unmarshal(originalJSONEntries, &entries); err != nil {
+		return nil, nil, err
+	}
+
+	return entries, originalJSONEntries, nil
+}
+
+// statusDelivery tells how to remind a user given their status and preferences.
+type statusDelivery int
+
+const (
+	statusDeliver statusDelivery = iota
+	statusDeliverSilently
+	statusHold
+	statusAway
+)
+
+// getStatusDelivery returns how to remind the user right now. Users whose status
+// cannot be fetched are reminded as usual.
+func (p *Plugin) getStatusDelivery(userID string) statusDelivery {
+	status, appErr := p.API.GetUserStatus(userID)
+	if appErr != nil {
+		return statusDeliver
+	}
+
+	preferences := p.getUserPreferences(userID)
+	switch status.Status {
+	case model.STATUS_DND:
+		switch preferences.DoNotDisturb {
+		case DoNotDisturbHold:
+			return statusHold
+		case DoNotDisturbSilent:
+			return statusDeliverSilently
+		}
+	case model.STATUS_OUT_OF_OFFICE:
+		if preferences.OutOfOffice != OutOfOfficeDeliver {
+			return statusAway
+		}
+	}
+	return statusDeliver
+}
+
+// deferWhileAway keeps the reminder for the summary the user gets when they are
+// back in the office.
+func (p *Plugin) deferWhileAway(reminder *Reminder, userID string) error {
+	return p.listManager.AddAwayEntry(userID, &AwayEntry{
+		ReminderID: reminder.ID,
+		PostID:     reminder.PostID,
+		Message:    reminder.Message,
+		CreateBy:   reminder.CreateBy,
+		RemindAt:   reminder.When,
+	})
+}
+
+// UserHasLoggedIn sends the summary of the reminders due while the user was out of
+// office, if they are back.
+func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
+	p.withAwayLock(func() {
+		p.sendAwaySummary(user.Id)
+	})
+}
+
+// sendAwaySummaries sends the summary of the reminders due while they were out of
+// office to every user who is back.
+func (p *Plugin) sendAwaySummaries() {
+	p.withAwayLock(func() {
+		userIDs, err := p.listManager.GetAwayUserIDs()
+		if err != nil {
+			p.API.LogError("Unable to get the users out of office. err=" + err.Error())
+			return
+		}
+
+		for _, userID := range userIDs {
+			p.sendAwaySummary(userID)
+		}
+	})
+}
+
+// withAwayLock runs f unless another node is sending away summaries.
+func (p *Plugin) withAwayLock(f func()) {
+	mutex := newClusterMutex(p.API, lockKey("away"))
+	locked, err := mutex.TryLock(awayLease)
+	if err != nil {
+		p.API.LogError("Unable to lock the away summaries. err=" + err.Error())
+		return
+	}
+	if !locked {
+		return
+	}
+	defer func() { _ = mutex.Unlock() }()
+
+	f()
+}
+
+// sendAwaySummary lists the reminders due while the user was out of office, once
+// they are back, and forgets them.
+func (p *Plugin) sendAwaySummary(userID string) {
+	if status, appErr := p.API.GetUserStatus(userID); appErr != nil || status.Status == model.STATUS_OUT_OF_OFFICE {
+		return
+	}
+
+	entries, err := p.listManager.GetAwayEntries(userID)
+	if err != nil {
+		p.API.LogError("Unable to get the reminders due while out of office. err=" + err.Error())
+		return
+	}
+	if len(entries) == 0 {
+		// The entry may never have been stored after the user was added to the index.
+		if err := p.listManager.RemoveAwayEntries(userID, 0); err != nil {
+			p.API.LogError("Unable to remove the reminders due while out of office. err=" + err.Error())
+		}
+		return
+	}
+
+	loc := p.getUserLocation(userID)
+	var sb strings.Builder
+	sb.WriteString("Welcome back! These reminders came due while you were out of office:\n")
+	listed := 0
+	for _, entry := range entries {
+		post, appErr := p.API.GetPost(entry.PostID)
+		if appErr != nil || post.DeleteAt != 0 {
+			continue
+		}
+		channel, appErr := p.API.GetChannel(post.ChannelId)
+		if appErr != nil || !canReadChannel(p.API, userID, channel) {
+			continue
+		}
+
+		sb.WriteString(fmt.Sprintf("* %s, [post](%s) in %s", formatUserTime(entry.RemindAt, loc), p.postPermalink(userID, post, channel), channelDisplayName(channel)))
+		if entry.CreateBy != userID {
+			sb.WriteString(fmt.Sprintf(", from @%s", p.listManager.GetUserName(entry.CreateBy)))
+		}
+		if entry.Message != "" {
+			sb.WriteString(": " + strings.ReplaceAll(entry.Message, "\n", " "))
+		}
+		sb.WriteString("\n")
+		listed++
+	}
+
+	// The posts of all the reminders may have been deleted in the meantime.
+	if listed > 0 {
+		if err := p.PostBotDM(userID, sb.String()); err != nil {
+			return
+		}
+	}
+	if err := p.listManager.RemoveAwayEntries(userID, len(entries)); err != nil {
+		p.API.LogError("Unable to remove the reminders due while out of office. err=" + err.Error())
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAwayEntries(t *testing.T) {
+	kv := map[string][]byte{}
+	api := newMemoryKVAPI(kv)
+	store := &listStore{api: api}
+
+	first := &AwayEntry{ReminderID: "reminder1", PostID: "post1", CreateBy: "user1", RemindAt: 1791972000000}
+	second := &AwayEntry{ReminderID: "reminder2", PostID: "post2", CreateBy: "user2", RemindAt: 1792058400000}
+	require.NoError(t, store.AddAwayEntry("user1", first))
+	require.NoError(t, store.AddAwayEntry("user1", second))
+
+	userIDs, err := store.GetAwayUserIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1"}, userIDs)
+	api.AssertNotCalled(t, "KVList", mock.Anything, mock.Anything)
+
+	entries, err := store.GetAwayEntries("user1")
+	require.NoError(t, err)
+	assert.Equal(t, []*AwayEntry{first, second}, entries)
+
+	// Entries added after the summary was sent are kept.
+	require.NoError(t, store.RemoveAwayEntries("user1", 1))
+	entries, err = store.GetAwayEntries("user1")
+	require.NoError(t, err)
+	assert.Equal(t, []*AwayEntry{second}, entries)
+
+	require.NoError(t, store.RemoveAwayEntries("user1", 1))
+	assert.NotContains(t, kv, awayKey("user1"))
+	userIDs, err = store.GetAwayUserIDs()
+	require.NoError(t, err)
+	assert.Empty(t, userIDs)
+}
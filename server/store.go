@@ -3,7 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/mattermost/mattermost-server/v5/model"
 	"github.com/mattermost/mattermost-server/v5/plugin"
 	"github.com/pkg/errors"
 )
@@ -11,10 +14,30 @@ import (
 const (
 	// StoreRetries is the number of retries to use when storing lists fails on a race
 	StoreRetries = 3
-	// StoreListKey is the key used to store lists in the plugin KV store.
+	// StoreListKey is the key of the global list of pending reminders, replaced by the due index.
 	StoreListKey = "reminders"
+	// StoreFailedListKey is the key used to store the list of reminders that could not be delivered.
+	StoreFailedListKey = "failed_reminders"
 	// StoreIssueKey is the key used to store issues in the plugin KV store.
 	StoreIssueKey = "item"
+	// StoreUserListKey is the key prefix used to store the list of pending reminders of a user.
+	StoreUserListKey = "user"
+	// StoreDueKey is the key prefix of the due index, which keeps the pending reminders
+	// of every user by the hour they are due in, so that users never write the same key.
+	StoreDueKey = "due"
+	// StoreDueBucketKey is the key prefix of the users with reminders in each bucket of
+	// the due index, so that the buckets due soon are read without listing the store.
+	// Each user is listed in a slot key of its own, numbered from 0.
+	StoreDueBucketKey = "duebucket"
+	// StoreSchemaVersionKey is the key of the version of the layout of the plugin KV store.
+	StoreSchemaVersionKey = "schema_version"
+
+	// dueBucketSize is the period of time covered by one key of the due index.
+	dueBucketSize = time.Hour
+	// dueBucketRetention is how long the users of a bucket are kept once it is over.
+	dueBucketRetention = 24 * time.Hour
+	// keyListPageSize is the number of keys fetched at once when listing the KV store.
+	keyListPageSize = 1000
 )
 
 type ReminderRef struct {
@@ -26,6 +49,27 @@ func listKey() string {
 	return StoreListKey
 }
 
+func failedListKey() string {
+	return StoreFailedListKey
+}
+
+func userListKey(userID string) string {
+	return fmt.Sprintf("%s_%s", StoreUserListKey, userID)
+}
+
+// dueBucket returns the start of the bucket of the due index the date falls in.
+func dueBucket(remindDate int64) int64 {
+	return remindDate - remindDate%dueBucketSize.Milliseconds()
+}
+
+func dueKey(userID string, remindDate int64) string {
+	return fmt.Sprintf("%s_%d_%s", StoreDueKey, dueBucket(remindDate), userID)
+}
+
+func dueBucketKey(bucket int64, slot int) string {
+	return fmt.Sprintf("%s_%d_%d", StoreDueBucketKey, bucket, slot)
+}
+
 func issueKey(issueID string) string {
 	return fmt.Sprintf("%s_%s", StoreIssueKey, issueID)
 }
@@ -90,9 +134,45 @@ func (l *listStore) GetAndRemoveReminder(issueID string) (*Reminder, error) {
 	return issue, nil
 }
 
-func (l listStore) AddReference(remindDate int64, issueID string) error {
+func (l *listStore) AddDueReference(userID string, remindDate int64, issueID string) error {
+	list, _, err := l.getList(dueKey(userID, remindDate))
+	if err != nil {
+		return err
+	}
+
+	// The user is added to the bucket before the first reference of its shard, so that
+	// a reload never misses the reference.
+	if len(list) == 0 {
+		if err := l.addBucketUser(dueBucket(remindDate), userID); err != nil {
+			return err
+		}
+	}
+	return l.addReference(dueKey(userID, remindDate), remindDate, issueID)
+}
+
+func (l *listStore) RemoveDueReference(userID string, remindDate int64, issueID string) error {
+	return l.removeReference(dueKey(userID, remindDate), issueID)
+}
+
+func (l *listStore) AddFailedReference(issueID string) error {
+	return l.addReference(failedListKey(), model.GetMillis(), issueID)
+}
+
+func (l *listStore) RemoveFailedReference(issueID string) error {
+	return l.removeReference(failedListKey(), issueID)
+}
+
+func (l *listStore) AddUserReference(userID string, remindDate int64, issueID string) error {
+	return l.addReference(userListKey(userID), remindDate, issueID)
+}
+
+func (l *listStore) RemoveUserReference(userID, issueID string) error {
+	return l.removeReference(userListKey(userID), issueID)
+}
+
+func (l *listStore) addReference(key string, remindDate int64, issueID string) error {
 	for i := 0; i < StoreRetries; i++ {
-		list, originalJSONList, err := l.getList()
+		list, originalJSONList, err := l.getList(key)
 		if err != nil {
 			return err
 		}
@@ -108,7 +188,7 @@ func (l listStore) AddReference(remindDate int64, issueID string) error {
 			ReminderDate: remindDate,
 		})
 
-		ok, err := l.saveList(list, originalJSONList)
+		ok, err := l.saveList(key, list, originalJSONList)
 		if err != nil {
 			return err
 		}
@@ -123,9 +203,9 @@ func (l listStore) AddReference(remindDate int64, issueID string) error {
 	return errors.New("unable to store installation")
 }
 
-func (l *listStore) RemoveReference(issueID string) error {
+func (l *listStore) removeReference(key string, issueID string) error {
 	for i := 0; i < StoreRetries; i++ {
-		list, originalJSONList, err := l.getList()
+		list, originalJSONList, err := l.getList(key)
 		if err != nil {
 			return err
 		}
@@ -142,7 +222,7 @@ func (l *listStore) RemoveReference(issueID string) error {
 			return errors.New("cannot find issue")
 		}
 
-		ok, err := l.saveList(list, originalJSONList)
+		ok, err := l.saveList(key, list, originalJSONList)
 		if err != nil {
 			return err
 		}
@@ -165,45 +245,169 @@ func NewListStore(api plugin.API) ListStore {
 }
 
 func (l *listStore) GetList() ([]*ReminderRef, error) {
-	irs, _, err := l.getList()
+	irs, _, err := l.getList(listKey())
+	return irs, err
+}
+
+func (l *listStore) GetFailedList() ([]*ReminderRef, error) {
+	irs, _, err := l.getList(failedListKey())
+	return irs, err
+}
+
+func (l *listStore) GetUserList(userID string) ([]*ReminderRef, error) {
+	irs, _, err := l.getList(userListKey(userID))
 	return irs, err
 }
 
-func (l *listStore) getList() ([]*ReminderRef, []byte, error) {
-	originalJSONList, err := l.api.KVGet(listKey())
+// GetUserIDs returns the users who have a list of reminders.
+func (l *listStore) GetUserIDs() ([]string, error) {
+	prefix := StoreUserListKey + "_"
+	keys, err := l.listKeys(prefix)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	if originalJSONList == nil {
-		return []*ReminderRef{}, originalJSONList, nil
+	userIDs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		userIDs = append(userIDs, strings.TrimPrefix(key, prefix))
 	}
+	return userIDs, nil
+}
 
-	var list []*ReminderRef
-	jsonErr := json.Unmarshal(originalJSONList, &list)
-	if jsonErr != nil {
-		return l.legacyIssueRef()
+// GetDueList returns the references of every pending reminder, read from all the
+// keys of the due index.
+func (l *listStore) GetDueList() ([]*ReminderRef, error) {
+	keys, err := l.listKeys(StoreDueKey + "_")
+	if err != nil {
+		return nil, err
 	}
 
-	return list, originalJSONList, nil
+	irs := []*ReminderRef{}
+	for _, key := range keys {
+		list, _, err := l.getList(key)
+		if err != nil {
+			return nil, err
+		}
+		irs = append(irs, list...)
+	}
+
+	return irs, nil
 }
 
-func (l *listStore) saveList(list []*ReminderRef, originalJSONList []byte) (bool, error) {
-	newJSONList, jsonErr := json.Marshal(list)
-	if jsonErr != nil {
-		return false, jsonErr
+// GetDueListBetween returns the references of the due index in the buckets from the
+// one of since to the one of until. Only the shards of the users listed in these
+// buckets are read.
+func (l *listStore) GetDueListBetween(since, until int64) ([]*ReminderRef, error) {
+	irs := []*ReminderRef{}
+	for bucket := dueBucket(since); bucket <= until; bucket += dueBucketSize.Milliseconds() {
+		userIDs, err := l.getBucketUsers(bucket)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, userID := range userIDs {
+			list, _, err := l.getList(dueKey(userID, bucket))
+			if err != nil {
+				return nil, err
+			}
+			irs = append(irs, list...)
+		}
 	}
 
-	ok, appErr := l.api.KVCompareAndSet(listKey(), originalJSONList, newJSONList)
-	if appErr != nil {
-		return false, errors.New(appErr.Error())
+	return irs, nil
+}
+
+// getBucketUsers returns the users with reminders in the bucket of the due index.
+func (l *listStore) getBucketUsers(bucket int64) ([]string, error) {
+	userIDs := []string{}
+	for slot := 0; ; slot++ {
+		value, appErr := l.api.KVGet(dueBucketKey(bucket, slot))
+		if appErr != nil {
+			return nil, errors.New(appErr.Error())
+		}
+		if value == nil {
+			return userIDs, nil
+		}
+
+		if !containsString(userIDs, string(value)) {
+			userIDs = append(userIDs, string(value))
+		}
 	}
+}
 
-	return ok, nil
+// addBucketUser adds the user to the bucket of the due index, unless already there.
+// The user takes the first free slot of the bucket. A slot is only ever created, by
+// a single user, so that users never write the same key and never leave a gap.
+func (l *listStore) addBucketUser(bucket int64, userID string) error {
+	expireInSeconds := (bucket + (dueBucketSize + dueBucketRetention).Milliseconds() - model.GetMillis()) / 1000
+	if expireInSeconds < 1 {
+		// A bucket over for that long is only read by a full load of the due index.
+		return nil
+	}
+
+	for slot := 0; ; slot++ {
+		value, appErr := l.api.KVGet(dueBucketKey(bucket, slot))
+		if appErr != nil {
+			return errors.New(appErr.Error())
+		}
+
+		if value == nil {
+			created, appErr := l.api.KVSetWithOptions(dueBucketKey(bucket, slot), []byte(userID), model.PluginKVSetOptions{
+				Atomic:          true,
+				OldValue:        nil,
+				ExpireInSeconds: expireInSeconds,
+			})
+			if appErr != nil {
+				return errors.New(appErr.Error())
+			}
+			if created {
+				return nil
+			}
+
+			// Another user took the slot first.
+			if value, appErr = l.api.KVGet(dueBucketKey(bucket, slot)); appErr != nil {
+				return errors.New(appErr.Error())
+			}
+		}
+
+		if string(value) == userID {
+			return nil
+		}
+	}
+}
+
+// listKeys returns the keys of the KV store that start with prefix.
+func (l *listStore) listKeys(prefix string) ([]string, error) {
+	keys := []string{}
+	for page := 0; ; page++ {
+		pageKeys, appErr := l.api.KVList(page, keyListPageSize)
+		if appErr != nil {
+			return nil, errors.New(appErr.Error())
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < keyListPageSize {
+			return keys, nil
+		}
+	}
 }
 
-func (l *listStore) legacyIssueRef() ([]*ReminderRef, []byte, error) {
-	originalJSONList, err := l.api.KVGet(listKey())
+func containsReference(refs []*ReminderRef, issueID string) bool {
+	for _, ref := range refs {
+		if ref.ReminderID == issueID {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *listStore) getList(key string) ([]*ReminderRef, []byte, error) {
+	originalJSONList, err := l.api.KVGet(key)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -212,16 +416,34 @@ func (l *listStore) legacyIssueRef() ([]*ReminderRef, []byte, error) {
 		return []*ReminderRef{}, originalJSONList, nil
 	}
 
-	var list []string
+	var list []*ReminderRef
 	jsonErr := json.Unmarshal(originalJSONList, &list)
 	if jsonErr != nil {
 		return nil, nil, jsonErr
 	}
 
-	newList := []*ReminderRef{}
-	for _, v := range list {
-		newList = append(newList, &ReminderRef{ReminderID: v})
+	return list, originalJSONList, nil
+}
+
+func (l *listStore) saveList(key string, list []*ReminderRef, originalJSONList []byte) (bool, error) {
+	if len(list) == 0 && originalJSONList != nil {
+		// Empty lists are deleted so that the keys of the due index do not pile up.
+		ok, appErr := l.api.KVCompareAndDelete(key, originalJSONList)
+		if appErr != nil {
+			return false, errors.New(appErr.Error())
+		}
+		return ok, nil
 	}
 
-	return newList, originalJSONList, nil
+	newJSONList, jsonErr := json.Marshal(list)
+	if jsonErr != nil {
+		return false, jsonErr
+	}
+
+	ok, appErr := l.api.KVCompareAndSet(key, originalJSONList, newJSONList)
+	if appErr != nil {
+		return false, errors.New(appErr.Error())
+	}
+
+	return ok, nil
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+	"github.com/mattermost/mattermost-server/v5/plugin/plugintest/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDueKey(t *testing.T) {
+	hour := time.Date(2026, time.October, 14, 10, 0, 0, 0, time.UTC).UnixNano() / int64(time.Millisecond)
+	minute := time.Minute.Milliseconds()
+
+	assert.Equal(t, "due_1791972000000_user1", dueKey("user1", hour))
+	assert.Equal(t, dueKey("user1", hour), dueKey("user1", hour+59*minute))
+	assert.NotEqual(t, dueKey("user1", hour), dueKey("user1", hour+60*minute))
+	assert.NotEqual(t, dueKey("user1", hour), dueKey("user2", hour))
+}
+
+func TestGetUserIDs(t *testing.T) {
+	kv := map[string][]byte{}
+	store := &listStore{api: newMemoryKVAPI(kv)}
+
+	require.NoError(t, store.AddUserReference("user1", 1791972000000, "reminder1"))
+	require.NoError(t, store.AddUserReference("user2", 1791972000000, "reminder2"))
+	require.NoError(t, store.AddDueReference("user3", 1791972000000, "reminder3"))
+
+	userIDs, err := store.GetUserIDs()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2"}, userIDs)
+}
+
+func TestGetDueListBetween(t *testing.T) {
+	kv := map[string][]byte{}
+	api := newMemoryKVAPI(kv)
+	store := &listStore{api: api}
+	hour := time.Hour.Milliseconds()
+	start := dueBucket(model.GetMillis()) + hour
+
+	require.NoError(t, store.AddDueReference("user1", start+10, "reminder1"))
+	require.NoError(t, store.AddDueReference("user2", start+hour+10, "reminder2"))
+	require.NoError(t, store.AddDueReference("user1", start+hour+20, "reminder3"))
+	require.NoError(t, store.AddDueReference("user1", start+hour+30, "reminder4"))
+	require.NoError(t, store.AddDueReference("user1", start+3*hour, "reminder5"))
+	// Each user of a bucket has a slot of its own.
+	assert.Equal(t, "user2", string(kv[dueBucketKey(start+hour, 0)]))
+	assert.Equal(t, "user1", string(kv[dueBucketKey(start+hour, 1)]))
+	assert.NotContains(t, kv, dueBucketKey(start+hour, 2))
+
+	refs, err := store.GetDueListBetween(start+hour+30, start+2*hour+30)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*ReminderRef{
+		{ReminderID: "reminder2", ReminderDate: start + hour + 10},
+		{ReminderID: "reminder3", ReminderDate: start + hour + 20},
+		{ReminderID: "reminder4", ReminderDate: start + hour + 30},
+	}, refs)
+	api.AssertNotCalled(t, "KVList", mock.Anything, mock.Anything)
+
+	// The reconciler lists users again when their slot is lost.
+	delete(kv, dueBucketKey(start+3*hour, 0))
+	report := &ReconcileReport{}
+	require.NoError(t, store.syncDueBuckets(report))
+	assert.Equal(t, &ReconcileReport{Added: 1}, report)
+	assert.Equal(t, "user1", string(kv[dueBucketKey(start+3*hour, 0)]))
+}
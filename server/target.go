@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v5/model"
+)
+
+// ReminderTarget tells who is reminded besides, or instead of, the owner of a
+// reminder. The owner alone is reminded when a reminder has no target.
+type ReminderTarget struct {
+	// UserIDs are the users reminded by a direct message.
+	UserIDs []string `json:"user_ids,omitempty"`
+	// ChannelID is the channel the reminder is posted in.
+	ChannelID string `json:"channel_id,omitempty"`
+	// GroupName is a group mentioned by the post in ChannelID. The plugin API has no
+	// way to list the members of a group, so they are reached through the mention.
+	GroupName string `json:"group_name,omitempty"`
+}
+
+// TargetError explains why a mention cannot be reminded.
+type TargetError struct {
+	Mention string
+	Reason  string
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf("cannot remind %s: %s", e.Mention, e.Reason)
+}
+
+// resolveTarget turns mentions like "@alice", "@developers" or "~release" into the
+// target of a reminder about the post. It returns a nil target when only the user
+// is reminded. Users must be able to read the post, and the user must be allowed
+// to post, and mention the group, in the channel.
+func (p *Plugin) resolveTarget(userID, postID string, mentions []string) (*ReminderTarget, error) {
+	if !canReadPost(p.API, userID, postID) {
+		return nil, errPostNotReadable
+	}
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		return nil, errPostNotReadable
+	}
+	postChannel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		return nil, errPostNotReadable
+	}
+
+	target := &ReminderTarget{}
+	includesSelf := false
+	for _, mention := range mentions {
+		mention = strings.TrimSpace(mention)
+		switch {
+		case mention == "me" || mention == "@me":
+			includesSelf = true
+		case strings.HasPrefix(mention, "~"):
+			if target.ChannelID != "" {
+				return nil, &TargetError{Mention: mention, Reason: "only one channel can be reminded"}
+			}
+			channel, err := p.resolveChannelMention(userID, postChannel, mention)
+			if err != nil {
+				return nil, err
+			}
+			target.ChannelID = channel.Id
+		case strings.HasPrefix(mention, "@"):
+			name := strings.TrimPrefix(mention, "@")
+			if user, appErr := p.API.GetUserByUsername(name); appErr == nil {
+				if user.DeleteAt != 0 || user.IsBot {
+					return nil, &TargetError{Mention: mention, Reason: "only active users can be reminded"}
+				}
+				if !canReadChannel(p.API, user.Id, postChannel) {
+					return nil, &TargetError{Mention: mention, Reason: "they cannot read the post"}
+				}
+				if user.Id == userID {
+					includesSelf = true
+				} else if !containsString(target.UserIDs, user.Id) {
+					target.UserIDs = append(target.UserIDs, user.Id)
+				}
+				continue
+			}
+
+			group, appErr := p.API.GetGroupByName(name)
+			if appErr != nil {
+				return nil, &TargetError{Mention: mention, Reason: "no such user or group"}
+			}
+			if target.GroupName != "" {
+				return nil, &TargetError{Mention: mention, Reason: "only one group can be reminded"}
+			}
+			if !group.AllowReference || group.DeleteAt != 0 {
+				return nil, &TargetError{Mention: mention, Reason: "the group cannot be mentioned"}
+			}
+			target.GroupName = name
+		default:
+			return nil, &TargetError{Mention: mention, Reason: "expected @user, @group or ~channel"}
+		}
+	}
+
+	if target.GroupName != "" {
+		// Groups are reminded by a mention in the channel, the one of the post by default.
+		if target.ChannelID == "" {
+			if postChannel.IsGroupOrDirect() {
+				return nil, &TargetError{Mention: "@" + target.GroupName, Reason: "groups are reminded in a channel, add one with ~channel"}
+			}
+			if !p.API.HasPermissionToChannel(userID, postChannel.Id, model.PERMISSION_CREATE_POST) {
+				return nil, &TargetError{Mention: "@" + target.GroupName, Reason: "you cannot post in the channel of the post"}
+			}
+			target.ChannelID = postChannel.Id
+		}
+		if !p.API.HasPermissionToChannel(userID, target.ChannelID, model.PERMISSION_USE_GROUP_MENTIONS) {
+			return nil, &TargetError{Mention: "@" + target.GroupName, Reason: "you cannot mention groups in the channel"}
+		}
+	}
+
+	if includesSelf {
+		target.UserIDs = append([]string{userID}, target.UserIDs...)
+	}
+	if target.ChannelID == "" && len(target.UserIDs) == 1 && target.UserIDs[0] == userID {
+		return nil, nil
+	}
+
+	return target, nil
+}
+
+// resolveChannelMention finds a channel of the team of the post in which the user
+// can post. A post of a private channel can only be reminded in its own channel.
+func (p *Plugin) resolveChannelMention(userID string, postChannel *model.Channel, mention string) (*model.Channel, error) {
+	if postChannel.IsGroupOrDirect() {
+		return nil, &TargetError{Mention: mention, Reason: "posts of direct messages cannot be reminded in a channel"}
+	}
+
+	channel, appErr := p.API.GetChannelByName(postChannel.TeamId, strings.TrimPrefix(mention, "~"), false)
+	if appErr != nil || !canReadChannel(p.API, userID, channel) {
+		return nil, &TargetError{Mention: mention, Reason: "no such channel"}
+	}
+	if !p.API.HasPermissionToChannel(userID, channel.Id, model.PERMISSION_CREATE_POST) {
+		return nil, &TargetError{Mention: mention, Reason: "you cannot post in the channel"}
+	}
+	if postChannel.Type != model.CHANNEL_OPEN && channel.Id != postChannel.Id {
+		return nil, &TargetError{Mention: mention, Reason: "a post of a private channel can only be reminded in its channel"}
+	}
+
+	return channel, nil
+}
+
+// recipients returns the users reminded by a direct message.
+func (t *ReminderTarget) recipients(ownerID string) []string {
+	if t == nil {
+		return []string{ownerID}
+	}
+	return t.UserIDs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,594 @@
+// Package timeparse turns natural language phrases like "in 2 hours", "tomorrow
+// morning" or "next Tuesday at 3pm" into absolute times.
+//
+// Phrases are interpreted relative to a reference time, in the location of that
+// reference time, so callers pass the current time in the time zone of the user.
+package timeparse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHour is the hour used when a phrase names a day but no time, like "tomorrow".
+	DefaultHour = 9
+	// EndOfDayHour is the hour meant by "end of day".
+	EndOfDayHour = 17
+)
+
+// ParseError explains which part of a phrase could not be understood.
+type ParseError struct {
+	// Input is the phrase that was parsed.
+	Input string
+	// Token is the part of the phrase that caused the error, if any.
+	Token string
+	// Reason describes what was wrong.
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("cannot parse %q: %s", e.Input, e.Reason)
+	}
+	return fmt.Sprintf("cannot parse %q: %s: %q", e.Input, e.Reason, e.Token)
+}
+
+type unit int
+
+const (
+	unitMinute unit = iota
+	unitHour
+	unitDay
+	unitBusinessDay
+	unitWeek
+	unitMonth
+	unitYear
+)
+
+var units = map[string]unit{
+	"m": unitMinute, "min": unitMinute, "mins": unitMinute, "minute": unitMinute, "minutes": unitMinute,
+	"h": unitHour, "hr": unitHour, "hrs": unitHour, "hour": unitHour, "hours": unitHour,
+	"d": unitDay, "day": unitDay, "days": unitDay,
+	"w": unitWeek, "wk": unitWeek, "wks": unitWeek, "week": unitWeek, "weeks": unitWeek,
+	"month": unitMonth, "months": unitMonth,
+	"y": unitYear, "yr": unitYear, "yrs": unitYear, "year": unitYear, "years": unitYear,
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+	"fifteen": 15, "twenty": 20, "thirty": 30, "forty": 40, "forty-five": 45, "sixty": 60,
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+var months = map[string]time.Month{
+	"jan": time.January, "january": time.January,
+	"feb": time.February, "february": time.February,
+	"mar": time.March, "march": time.March,
+	"apr": time.April, "april": time.April,
+	"may": time.May,
+	"jun": time.June, "june": time.June,
+	"jul": time.July, "july": time.July,
+	"aug": time.August, "august": time.August,
+	"sep": time.September, "sept": time.September, "september": time.September,
+	"oct": time.October, "october": time.October,
+	"nov": time.November, "november": time.November,
+	"dec": time.December, "december": time.December,
+}
+
+// dayParts are the times of day that can be named.
+var dayParts = map[string]int{
+	"morning":   DefaultHour,
+	"noon":      12,
+	"midday":    12,
+	"afternoon": 14,
+	"evening":   18,
+	"night":     20,
+	"tonight":   20,
+	"midnight":  0,
+}
+
+var (
+	compactDurationRe = regexp.MustCompile(`^(\d+)([a-z]+)$`)
+	clockRe           = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm|a\.m\.|p\.m\.)?$`)
+	isoDateRe         = regexp.MustCompile(`^(\d{4})-(\d{2})-(\d{2})$`)
+	ordinalRe         = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+	yearRe            = regexp.MustCompile(`^\d{4}$`)
+)
+
+type clock struct {
+	hour, minute int
+}
+
+// phrase collects what has been recognized so far.
+type phrase struct {
+	input  string
+	now    time.Time
+	tokens []string
+	pos    int
+
+	// offset holds the relative parts, "in 2 hours" or "in 3 business days".
+	offsets      map[unit]int
+	hasOffset    bool
+	hasDayOffset bool
+
+	// date holds an explicit day, "tomorrow" or "next Tuesday".
+	date    *time.Time
+	dateTok string
+
+	// clock holds an explicit time of day, "at 3pm" or "morning".
+	clock    *clock
+	clockTok string
+}
+
+// Parse returns the time described by input, relative to now and in the location
+// of now. Phrases naming a day without a time use DefaultHour, and phrases naming
+// only a time of day mean its next occurrence.
+func Parse(input string, now time.Time) (time.Time, error) {
+	p := &phrase{
+		input:   input,
+		now:     now,
+		tokens:  tokenize(input),
+		offsets: map[unit]int{},
+	}
+
+	if len(p.tokens) == 0 {
+		return time.Time{}, &ParseError{Input: input, Reason: "no time given"}
+	}
+
+	for p.pos < len(p.tokens) {
+		if err := p.next(); err != nil {
+			return time.Time{}, err
+		}
+	}
+
+	return p.resolve()
+}
+
+// fillerWords are skipped wherever they appear in a phrase.
+var fillerWords = map[string]bool{
+	"in": true, "at": true, "on": true, "and": true, "by": true, "the": true, "this": true, "of": true, "from": true,
+}
+
+// IsFillerWord tells whether the word carries no meaning in a phrase, like "at" or
+// "the". A phrase that ends with one may have taken it from the text that follows.
+func IsFillerWord(word string) bool {
+	tokens := tokenize(word)
+	return len(tokens) > 0 && fillerWords[tokens[len(tokens)-1]]
+}
+
+func tokenize(input string) []string {
+	input = strings.ToLower(strings.TrimSpace(input))
+	input = strings.NewReplacer(",", " ", ";", " ").Replace(input)
+
+	tokens := []string{}
+	for _, token := range strings.Fields(input) {
+		// "3 pm" is read as "3pm".
+		if (token == "am" || token == "pm" || token == "a.m." || token == "p.m.") && len(tokens) > 0 && clockRe.MatchString(tokens[len(tokens)-1]) {
+			tokens[len(tokens)-1] += token
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+func (p *phrase) peek(offset int) string {
+	if p.pos+offset < len(p.tokens) {
+		return p.tokens[p.pos+offset]
+	}
+	return ""
+}
+
+func (p *phrase) errorf(token, format string, args ...interface{}) error {
+	return &ParseError{Input: p.input, Token: token, Reason: fmt.Sprintf(format, args...)}
+}
+
+// next consumes the tokens of one recognized part of the phrase.
+func (p *phrase) next() error {
+	token := p.peek(0)
+	if fillerWords[token] {
+		p.pos++
+		return nil
+	}
+
+	switch token {
+	case "now":
+		p.pos++
+		return p.addOffset(token, unitMinute, 0)
+	case "today":
+		p.pos++
+		return p.setDate(token, p.today())
+	case "tomorrow", "tmrw", "tmr":
+		p.pos++
+		return p.setDate(token, p.today().AddDate(0, 0, 1))
+	case "tonight":
+		p.pos++
+		if err := p.setDate(token, p.today()); err != nil {
+			return err
+		}
+		return p.setClock(token, clock{hour: dayParts[token]})
+	case "day":
+		if p.peek(1) == "after" && p.peek(2) == "tomorrow" {
+			p.pos += 3
+			return p.setDate("day after tomorrow", p.today().AddDate(0, 0, 2))
+		}
+	case "end", "eod", "eow", "eom":
+		return p.endOf()
+	case "next":
+		return p.nextPeriod()
+	case "half":
+		if p.peek(1) == "an" && p.peek(2) == "hour" {
+			p.pos += 3
+			return p.addOffset("half an hour", unitMinute, 30)
+		}
+	}
+
+	if hour, ok := dayParts[token]; ok {
+		p.pos++
+		return p.setClock(token, clock{hour: hour})
+	}
+
+	if wd, ok := weekdays[token]; ok {
+		p.pos++
+		return p.setDate(token, p.nextWeekday(wd))
+	}
+
+	if ok, err := p.tryDate(); ok || err != nil {
+		return err
+	}
+
+	if ok, err := p.tryDuration(); ok || err != nil {
+		return err
+	}
+
+	if m := clockRe.FindStringSubmatch(token); m != nil {
+		c, err := parseClock(m)
+		if err != nil {
+			return p.errorf(token, "%s", err.Error())
+		}
+		p.pos++
+		return p.setClock(token, c)
+	}
+
+	return p.errorf(token, "unknown word")
+}
+
+// tryDuration recognizes "2 hours", "an hour", "3 business days" and "90min".
+func (p *phrase) tryDuration() (bool, error) {
+	token := p.peek(0)
+
+	if m := compactDurationRe.FindStringSubmatch(token); m != nil {
+		if u, ok := units[m[2]]; ok {
+			n, _ := strconv.Atoi(m[1])
+			p.pos++
+			return true, p.addOffset(token, u, n)
+		}
+	}
+
+	n, err := strconv.Atoi(token)
+	if err != nil {
+		var ok bool
+		if n, ok = numberWords[token]; !ok {
+			return false, nil
+		}
+	}
+
+	unitToken := p.peek(1)
+	if unitToken == "business" || unitToken == "working" || unitToken == "work" {
+		if dayToken := p.peek(2); dayToken == "day" || dayToken == "days" {
+			p.pos += 3
+			return true, p.addOffset(token+" "+unitToken+" "+dayToken, unitBusinessDay, n)
+		}
+		return true, p.errorf(unitToken, "expected days after")
+	}
+	if unitToken == "workday" || unitToken == "workdays" || unitToken == "weekday" || unitToken == "weekdays" {
+		p.pos += 2
+		return true, p.addOffset(token+" "+unitToken, unitBusinessDay, n)
+	}
+
+	if u, ok := units[unitToken]; ok {
+		p.pos += 2
+		return true, p.addOffset(token+" "+unitToken, u, n)
+	}
+
+	// A bare number is a clock time, like "at 9", unless it follows "in".
+	_, isNumberWord := numberWords[token]
+	afterIn := p.pos > 0 && p.tokens[p.pos-1] == "in"
+	if (isNumberWord && !clockRe.MatchString(token)) || (err == nil && afterIn) {
+		return true, p.errorf(token, "expected a unit like minutes, hours or days after")
+	}
+	return false, nil
+}
+
+// tryDate recognizes "2026-10-20", "oct 20", "october 20th 2027" and "20 oct".
+func (p *phrase) tryDate() (bool, error) {
+	token := p.peek(0)
+
+	if m := isoDateRe.FindStringSubmatch(token); m != nil {
+		year, _ := strconv.Atoi(m[1])
+		month, _ := strconv.Atoi(m[2])
+		day, _ := strconv.Atoi(m[3])
+		date, err := p.makeDate(token, year, time.Month(month), day)
+		if err != nil {
+			return true, err
+		}
+		p.pos++
+		return true, p.setDate(token, date)
+	}
+
+	if month, ok := months[token]; ok {
+		m := ordinalRe.FindStringSubmatch(p.peek(1))
+		if m == nil {
+			return true, p.errorf(token, "expected a day of month after")
+		}
+		day, _ := strconv.Atoi(m[1])
+		p.pos += 2
+		return true, p.finishMonthDate(token+" "+p.peek(-1), month, day)
+	}
+
+	if m := ordinalRe.FindStringSubmatch(token); m != nil {
+		if month, ok := months[p.peek(1)]; ok {
+			day, _ := strconv.Atoi(m[1])
+			p.pos += 2
+			return true, p.finishMonthDate(token+" "+p.peek(-1), month, day)
+		}
+	}
+
+	return false, nil
+}
+
+// finishMonthDate reads an optional year and picks the next occurrence otherwise.
+func (p *phrase) finishMonthDate(token string, month time.Month, day int) error {
+	year := p.now.Year()
+	explicitYear := false
+	if yearRe.MatchString(p.peek(0)) {
+		year, _ = strconv.Atoi(p.peek(0))
+		token += " " + p.peek(0)
+		explicitYear = true
+		p.pos++
+	}
+
+	date, err := p.makeDate(token, year, month, day)
+	if err != nil {
+		return err
+	}
+	if !explicitYear && date.Before(p.today()) {
+		if date, err = p.makeDate(token, year+1, month, day); err != nil {
+			return err
+		}
+	}
+
+	return p.setDate(token, date)
+}
+
+func (p *phrase) makeDate(token string, year int, month time.Month, day int) (time.Time, error) {
+	date := time.Date(year, month, day, 0, 0, 0, 0, p.now.Location())
+	if month < time.January || month > time.December || date.Day() != day {
+		return time.Time{}, p.errorf(token, "no such date")
+	}
+	return date, nil
+}
+
+// endOf recognizes "end of day", "end of the week", "end of month" and their abbreviations.
+func (p *phrase) endOf() error {
+	token := p.peek(0)
+	period := map[string]string{"eod": "day", "eow": "week", "eom": "month"}[token]
+	consumed := 1
+
+	if token == "end" {
+		i := 1
+		if p.peek(i) == "of" {
+			i++
+		}
+		if p.peek(i) == "the" {
+			i++
+		}
+		period = p.peek(i)
+		consumed = i + 1
+		end := p.pos + consumed
+		if end > len(p.tokens) {
+			end = len(p.tokens)
+		}
+		token = strings.Join(p.tokens[p.pos:end], " ")
+	}
+
+	today := p.today()
+	var date time.Time
+	switch period {
+	case "day":
+		date = today
+		if p.now.Hour() >= EndOfDayHour {
+			date = date.AddDate(0, 0, 1)
+		}
+	case "week":
+		date = today.AddDate(0, 0, (int(time.Friday)-int(today.Weekday())+7)%7)
+		if date.Equal(today) && p.now.Hour() >= EndOfDayHour {
+			date = date.AddDate(0, 0, 7)
+		}
+	case "month":
+		date = time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location())
+		if date.Equal(today) && p.now.Hour() >= EndOfDayHour {
+			date = time.Date(today.Year(), today.Month()+2, 0, 0, 0, 0, 0, today.Location())
+		}
+	default:
+		return p.errorf(token, "expected day, week or month after end of")
+	}
+
+	p.pos += consumed
+	if err := p.setDate(token, date); err != nil {
+		return err
+	}
+	return p.setClock(token, clock{hour: EndOfDayHour})
+}
+
+// nextPeriod recognizes "next week", "next month" and "next Tuesday".
+func (p *phrase) nextPeriod() error {
+	target := p.peek(1)
+	token := "next " + target
+	today := p.today()
+
+	if wd, ok := weekdays[target]; ok {
+		p.pos += 2
+		return p.setDate(token, p.nextWeekday(wd))
+	}
+
+	switch target {
+	case "week":
+		p.pos += 2
+		daysToMonday := (int(time.Monday) - int(today.Weekday()) + 7) % 7
+		if daysToMonday == 0 {
+			daysToMonday = 7
+		}
+		return p.setDate(token, today.AddDate(0, 0, daysToMonday))
+	case "month":
+		p.pos += 2
+		return p.setDate(token, time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, today.Location()))
+	case "year":
+		p.pos += 2
+		return p.setDate(token, time.Date(today.Year()+1, time.January, 1, 0, 0, 0, 0, today.Location()))
+	case "":
+		return p.errorf("next", "expected a day, week, month or year after")
+	}
+
+	return p.errorf(token, "expected a day, week, month or year after next")
+}
+
+func (p *phrase) addOffset(token string, u unit, n int) error {
+	if p.date != nil {
+		return p.errorf(token, "cannot combine a duration with %q", p.dateTok)
+	}
+	p.offsets[u] += n
+	p.hasOffset = true
+	if u >= unitDay {
+		p.hasDayOffset = true
+	}
+	return nil
+}
+
+func (p *phrase) setDate(token string, date time.Time) error {
+	if p.date != nil {
+		return p.errorf(token, "day already given as %q", p.dateTok)
+	}
+	if p.hasOffset {
+		return p.errorf(token, "cannot combine a day with a duration")
+	}
+	p.date = &date
+	p.dateTok = token
+	return nil
+}
+
+func (p *phrase) setClock(token string, c clock) error {
+	if p.clock != nil {
+		return p.errorf(token, "time already given as %q", p.clockTok)
+	}
+	p.clock = &c
+	p.clockTok = token
+	return nil
+}
+
+func parseClock(m []string) (clock, error) {
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	suffix := strings.ReplaceAll(m[3], ".", "")
+
+	switch suffix {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return clock{}, fmt.Errorf("hour must be between 1 and 12 with %s", suffix)
+		}
+		hour %= 12
+		if suffix == "pm" {
+			hour += 12
+		}
+	default:
+		if m[2] == "" && len(m[1]) > 2 {
+			return clock{}, fmt.Errorf("invalid time")
+		}
+		if hour > 23 {
+			return clock{}, fmt.Errorf("hour must be between 0 and 23")
+		}
+	}
+	if minute > 59 {
+		return clock{}, fmt.Errorf("minutes must be between 0 and 59")
+	}
+
+	return clock{hour: hour, minute: minute}, nil
+}
+
+func (p *phrase) today() time.Time {
+	return time.Date(p.now.Year(), p.now.Month(), p.now.Day(), 0, 0, 0, 0, p.now.Location())
+}
+
+// nextWeekday returns the next day with the given weekday, never today.
+func (p *phrase) nextWeekday(wd time.Weekday) time.Time {
+	today := p.today()
+	days := (int(wd) - int(today.Weekday()) + 7) % 7
+	if days == 0 {
+		days = 7
+	}
+	return today.AddDate(0, 0, days)
+}
+
+func (p *phrase) resolve() (time.Time, error) {
+	if !p.hasOffset && p.date == nil && p.clock == nil {
+		return time.Time{}, &ParseError{Input: p.input, Reason: "no time given"}
+	}
+
+	if p.hasOffset {
+		if p.clock != nil && !p.hasDayOffset {
+			return time.Time{}, p.errorf(p.clockTok, "cannot combine a time of day with a duration in minutes or hours")
+		}
+
+		t := p.now.AddDate(p.offsets[unitYear], p.offsets[unitMonth], 7*p.offsets[unitWeek]+p.offsets[unitDay])
+		t = addBusinessDays(t, p.offsets[unitBusinessDay])
+		t = t.Add(time.Duration(p.offsets[unitHour])*time.Hour + time.Duration(p.offsets[unitMinute])*time.Minute)
+		if p.clock != nil {
+			t = time.Date(t.Year(), t.Month(), t.Day(), p.clock.hour, p.clock.minute, 0, 0, t.Location())
+		}
+		return t, nil
+	}
+
+	if p.date != nil {
+		c := clock{hour: DefaultHour}
+		if p.clock != nil {
+			c = *p.clock
+		}
+		return time.Date(p.date.Year(), p.date.Month(), p.date.Day(), c.hour, c.minute, 0, 0, p.date.Location()), nil
+	}
+
+	// Only a time of day, which means its next occurrence.
+	today := p.today()
+	t := time.Date(today.Year(), today.Month(), today.Day(), p.clock.hour, p.clock.minute, 0, 0, today.Location())
+	if !t.After(p.now) {
+		t = time.Date(today.Year(), today.Month(), today.Day()+1, p.clock.hour, p.clock.minute, 0, 0, today.Location())
+	}
+	return t, nil
+}
+
+// addBusinessDays moves forward by the given number of days, skipping weekends.
+func addBusinessDays(t time.Time, days int) time.Time {
+	for days > 0 {
+		t = t.AddDate(0, 0, 1)
+		if t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return t
+}
@@ -0,0 +1,157 @@
+package timeparse
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	at := func(year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	// Wednesday, 2026-10-14 10:30 in New York.
+	now := at(2026, time.October, 14, 10, 30)
+	// Friday, 2026-10-16 18:00 in New York.
+	fridayEvening := at(2026, time.October, 16, 18, 0)
+
+	for _, tc := range []struct {
+		input    string
+		now      time.Time
+		expected time.Time
+	}{
+		// Durations.
+		{input: "now", expected: now},
+		{input: "in 2 hours", expected: at(2026, time.October, 14, 12, 30)},
+		{input: "in 90 minutes", expected: at(2026, time.October, 14, 12, 0)},
+		{input: "in an hour", expected: at(2026, time.October, 14, 11, 30)},
+		{input: "in half an hour", expected: at(2026, time.October, 14, 11, 0)},
+		{input: "in 1 hour and 15 minutes", expected: at(2026, time.October, 14, 11, 45)},
+		{input: "in 1h 15m", expected: at(2026, time.October, 14, 11, 45)},
+		{input: "20 min", expected: at(2026, time.October, 14, 10, 50)},
+		{input: "in two days", expected: at(2026, time.October, 16, 10, 30)},
+		{input: "in a week", expected: at(2026, time.October, 21, 10, 30)},
+		{input: "in 2 weeks", expected: at(2026, time.October, 28, 10, 30)},
+		{input: "in 1 month", expected: at(2026, time.November, 14, 10, 30)},
+		{input: "in a year", expected: at(2027, time.October, 14, 10, 30)},
+		{input: "in 2 days at 9am", expected: at(2026, time.October, 16, 9, 0)},
+
+		// Business days.
+		{input: "in 3 business days", expected: at(2026, time.October, 19, 10, 30)},
+		{input: "in 1 working day", expected: at(2026, time.October, 15, 10, 30)},
+		{input: "in 5 workdays", expected: at(2026, time.October, 21, 10, 30)},
+		{input: "in 1 business day", now: fridayEvening, expected: at(2026, time.October, 19, 18, 0)},
+		{input: "in 3 business days at 9am", expected: at(2026, time.October, 19, 9, 0)},
+
+		// Days.
+		{input: "today", expected: at(2026, time.October, 14, 9, 0)},
+		{input: "tomorrow", expected: at(2026, time.October, 15, 9, 0)},
+		{input: "tmrw", expected: at(2026, time.October, 15, 9, 0)},
+		{input: "day after tomorrow", expected: at(2026, time.October, 16, 9, 0)},
+		{input: "friday", expected: at(2026, time.October, 16, 9, 0)},
+		{input: "wednesday", expected: at(2026, time.October, 21, 9, 0)},
+		{input: "this Friday", expected: at(2026, time.October, 16, 9, 0)},
+		{input: "next tuesday", expected: at(2026, time.October, 20, 9, 0)},
+		{input: "next week", expected: at(2026, time.October, 19, 9, 0)},
+		{input: "next month", expected: at(2026, time.November, 1, 9, 0)},
+		{input: "next year", expected: at(2027, time.January, 1, 9, 0)},
+		{input: "2026-12-24", expected: at(2026, time.December, 24, 9, 0)},
+		{input: "Dec 24", expected: at(2026, time.December, 24, 9, 0)},
+		{input: "24th december", expected: at(2026, time.December, 24, 9, 0)},
+		{input: "march 1st", expected: at(2027, time.March, 1, 9, 0)},
+		{input: "march 1st 2028", expected: at(2028, time.March, 1, 9, 0)},
+
+		// Times of day.
+		{input: "at 3pm", expected: at(2026, time.October, 14, 15, 0)},
+		{input: "3 PM", expected: at(2026, time.October, 14, 15, 0)},
+		{input: "at 3:45 p.m.", expected: at(2026, time.October, 14, 15, 45)},
+		{input: "15:00", expected: at(2026, time.October, 14, 15, 0)},
+		{input: "at 9", expected: at(2026, time.October, 15, 9, 0)},
+		{input: "at 12am", expected: at(2026, time.October, 15, 0, 0)},
+		{input: "at 12pm", expected: at(2026, time.October, 14, 12, 0)},
+		{input: "noon", expected: at(2026, time.October, 14, 12, 0)},
+		{input: "midnight", expected: at(2026, time.October, 15, 0, 0)},
+		{input: "this afternoon", expected: at(2026, time.October, 14, 14, 0)},
+		{input: "evening", expected: at(2026, time.October, 14, 18, 0)},
+		{input: "tonight", expected: at(2026, time.October, 14, 20, 0)},
+		{input: "morning", expected: at(2026, time.October, 15, 9, 0)},
+
+		// Days and times.
+		{input: "tomorrow morning", expected: at(2026, time.October, 15, 9, 0)},
+		{input: "tomorrow at 14:30", expected: at(2026, time.October, 15, 14, 30)},
+		{input: "next Tuesday at 3pm", expected: at(2026, time.October, 20, 15, 0)},
+		{input: "at 3pm next tuesday", expected: at(2026, time.October, 20, 15, 0)},
+		{input: "monday, 8:15am", expected: at(2026, time.October, 19, 8, 15)},
+		{input: "on Dec 24 at noon", expected: at(2026, time.December, 24, 12, 0)},
+		{input: "today at 8am", expected: at(2026, time.October, 14, 8, 0)},
+
+		// Ends of periods.
+		{input: "end of day", expected: at(2026, time.October, 14, 17, 0)},
+		{input: "EOD", expected: at(2026, time.October, 14, 17, 0)},
+		{input: "end of day", now: fridayEvening, expected: at(2026, time.October, 17, 17, 0)},
+		{input: "end of the week", expected: at(2026, time.October, 16, 17, 0)},
+		{input: "eow", now: fridayEvening, expected: at(2026, time.October, 23, 17, 0)},
+		{input: "end of month", expected: at(2026, time.October, 31, 17, 0)},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			reference := now
+			if !tc.now.IsZero() {
+				reference = tc.now
+			}
+
+			actual, err := Parse(tc.input, reference)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected.String(), actual.String())
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Date(2026, time.October, 14, 10, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		input string
+		token string
+	}{
+		{input: "", token: ""},
+		{input: "   ", token: ""},
+		{input: "at", token: ""},
+		{input: "someday", token: "someday"},
+		{input: "in 2 fortnights", token: "2"},
+		{input: "in a bit", token: "a"},
+		{input: "in 2", token: "2"},
+		{input: "in 2 then", token: "2"},
+		{input: "tomorrow in 9", token: "9"},
+		{input: "in 3 business hours", token: "business"},
+		{input: "tomorrow friday", token: "friday"},
+		{input: "at 3pm at 4pm", token: "4pm"},
+		{input: "tomorrow in 2 hours", token: "2 hours"},
+		{input: "in 2 hours at 3pm", token: "3pm"},
+		{input: "at 13pm", token: "13pm"},
+		{input: "at 25:00", token: "25:00"},
+		{input: "at 10:75", token: "10:75"},
+		{input: "feb 30", token: "feb 30"},
+		{input: "2026-13-01", token: "2026-13-01"},
+		{input: "next", token: "next"},
+		{input: "next decade", token: "next decade"},
+		{input: "end of time", token: "end of time"},
+		{input: "june", token: "june"},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			_, err := Parse(tc.input, now)
+			require.Error(t, err)
+
+			parseErr, ok := err.(*ParseError)
+			require.True(t, ok)
+			assert.Equal(t, tc.input, parseErr.Input)
+			assert.Equal(t, tc.token, parseErr.Token)
+			assert.NotEmpty(t, parseErr.Reason)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-plugin-post-reminder/server/timeparse"
+	"github.com/pkg/errors"
+)
+
+// localTimeLayouts are the wall clock formats accepted for local reminder times.
+var localTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+}
+
+// getUserTimeZone returns the name of the time zone the user selected in Mattermost,
+// either the automatic or the manual one, or UTC when it is unknown.
+func (p *Plugin) getUserTimeZone(userID string) string {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return "UTC"
+	}
+
+	timeZone := user.GetPreferredTimezone()
+	if _, err := time.LoadLocation(timeZone); err != nil || timeZone == "" {
+		return "UTC"
+	}
+	return timeZone
+}
+
+// getUserLocation returns the location of the time zone the user selected in Mattermost.
+func (p *Plugin) getUserLocation(userID string) *time.Location {
+	loc, err := time.LoadLocation(p.getUserTimeZone(userID))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// formatUserTime renders a time in milliseconds for a user in the given location.
+func formatUserTime(millis int64, loc *time.Location) string {
+	return msToTime(millis).In(loc).Format("Mon, Jan 2 2006 at 15:04 MST")
+}
+
+// parseInstant parses an absolute point in time, given either as RFC 3339 or as
+// milliseconds since the epoch, and returns it in milliseconds.
+func parseInstant(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return millis, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, errors.Errorf("%q is neither an RFC 3339 time nor milliseconds since the epoch", value)
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+// parseLocalTime parses a wall clock time like "2006-01-02 15:04", or a natural
+// language phrase like "tomorrow at 9am", in the location of now.
+func parseLocalTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	return timeparse.Parse(value, now)
+}
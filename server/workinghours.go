@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// weekdayNames are the short names of the days of the week, Sunday first like time.Weekday.
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// WorkingHours are the times of the week a user wants to be reminded at, in their
+// time zone. Reminders set to be delivered at working time wait until then.
+type WorkingHours struct {
+	// Start and End are wall clock times like "09:00" and "17:30"; End is after Start.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Days are the working days, Sunday being 0.
+	Days []time.Weekday `json:"days"`
+}
+
+// IsValid checks the working hours.
+func (w *WorkingHours) IsValid() error {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if end <= start {
+		return errors.New("the working hours must end after they start")
+	}
+
+	if len(w.Days) == 0 {
+		return errors.New("at least one working day is needed")
+	}
+	for _, day := range w.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return errors.Errorf("invalid working day %d", day)
+		}
+	}
+	return nil
+}
+
+// NextWorkingTime returns t if it is within the working hours, or else the start of
+// the next working hours, in the location of t.
+func (w *WorkingHours) NextWorkingTime(t time.Time) time.Time {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return t
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return t
+	}
+
+	for i := 0; i <= 7; i++ {
+		day := t.AddDate(0, 0, i)
+		if !containsWeekday(w.Days, day.Weekday()) {
+			continue
+		}
+
+		dayStart := time.Date(day.Year(), day.Month(), day.Day(), start/60, start%60, 0, 0, t.Location())
+		if i > 0 || t.Before(dayStart) {
+			return dayStart
+		}
+		if t.Before(time.Date(day.Year(), day.Month(), day.Day(), end/60, end%60, 0, 0, t.Location())) {
+			return t
+		}
+	}
+	return t
+}
+
+// String renders the working hours like "09:00-17:00 mon,tue,wed".
+func (w *WorkingHours) String() string {
+	days := make([]string, 0, len(w.Days))
+	for _, day := range w.Days {
+		days = append(days, weekdayNames[day])
+	}
+	return fmt.Sprintf("%s-%s %s", w.Start, w.End, strings.Join(days, ","))
+}
+
+// parseClock returns the number of minutes since midnight of a time like "09:30".
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+// parseWeekdays parses days like "mon-fri" or "mon,wed,fri", Sunday first.
+func parseWeekdays(value string) ([]time.Weekday, error) {
+	selected := make([]bool, len(weekdayNames))
+	for _, part := range strings.Split(strings.ToLower(value), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first := weekdayIndex(bounds[0])
+		last := first
+		if len(bounds) == 2 {
+			last = weekdayIndex(bounds[1])
+		}
+		if first < 0 || last < 0 {
+			return nil, errors.Errorf("invalid days %q, expected e.g. mon-fri or mon,wed,fri", value)
+		}
+
+		for day := first; ; day = (day + 1) % len(weekdayNames) {
+			selected[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+
+	days := []time.Weekday{}
+	for day, ok := range selected {
+		if ok {
+			days = append(days, time.Weekday(day))
+		}
+	}
+	return days, nil
+}
+
+// weekdayIndex returns the index of a day named like "mon" or "monday", or -1.
+func weekdayIndex(name string) int {
+	name = strings.TrimSpace(name)
+	if len(name) < 3 {
+		return -1
+	}
+	for i := range weekdayNames {
+		if strings.HasPrefix(strings.ToLower(time.Weekday(i).String()), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWorkingTime returns the first instant from t at which the user is working, by
+// their working hours in their time zone. It is t for users without working hours.
+func (p *Plugin) nextWorkingTime(userID string, t time.Time) time.Time {
+	hours := p.getUserPreferences(userID).WorkingHours
+	if hours == nil {
+		return t
+	}
+	return hours.NextWorkingTime(t.In(p.getUserLocation(userID)))
+}
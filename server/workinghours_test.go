@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextWorkingTime(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	hours := &WorkingHours{Start: "09:00", End: "17:30", Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}}
+	require.NoError(t, hours.IsValid())
+
+	// 2026-10-16 is a Friday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, loc)
+	}
+
+	assert.Equal(t, at(16, 10, 0), hours.NextWorkingTime(at(16, 10, 0)), "during working hours")
+	assert.Equal(t, at(16, 9, 0), hours.NextWorkingTime(at(16, 7, 45)), "before they start")
+	assert.Equal(t, at(19, 9, 0), hours.NextWorkingTime(at(16, 17, 30)), "after they end on a Friday")
+	assert.Equal(t, at(19, 9, 0), hours.NextWorkingTime(at(18, 12, 0)), "on a Sunday")
+}
+
+func TestWorkingHoursIsValid(t *testing.T) {
+	weekdays := []time.Weekday{time.Monday}
+	assert.NoError(t, (&WorkingHours{Start: "00:00", End: "24:00", Days: weekdays}).IsValid())
+	assert.Error(t, (&WorkingHours{Start: "17:00", End: "09:00", Days: weekdays}).IsValid())
+	assert.Error(t, (&WorkingHours{Start: "9am", End: "17:00", Days: weekdays}).IsValid())
+	assert.Error(t, (&WorkingHours{Start: "09:00", End: "17:00"}).IsValid())
+	assert.Error(t, (&WorkingHours{Start: "09:00", End: "17:00", Days: []time.Weekday{7}}).IsValid())
+}
+
+func TestParseWeekdays(t *testing.T) {
+	days, err := parseWeekdays("mon-fri")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, days)
+
+	days, err = parseWeekdays("Saturday,sun,wed")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Sunday, time.Wednesday, time.Saturday}, days)
+
+	days, err = parseWeekdays("fri-mon")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Sunday, time.Monday, time.Friday, time.Saturday}, days)
+
+	_, err = parseWeekdays("weekdays")
+	assert.Error(t, err)
+}